@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SearchServer отдаёт обе версии API. Версия выбирается по префиксу пути (/v1, /v2),
+// а если его нет - по заголовку Accept. По умолчанию отвечает первая версия с заголовками исходного
+// контракта, а Content-Type версии, ETag и 304 получают только запросы, явно попросившие v1.
+// Пути, оканчивающиеся на /export, отдают NDJSON-выгрузку, а CSV и XML выбираются параметром format или заголовком Accept
+func SearchServer(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, exportPath) {
+		ExportUsers(w, r)
+		return
+	}
+	if format := negotiateFormat(r); format != formatJSON {
+		FormatUsers(w, r, format)
+		return
+	}
+
+	switch negotiateVersion(r) {
+	case APIVersion2:
+		QueryV2(w, r)
+	default:
+		if !explicitV1(r) {
+			QueryDummy(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", MimeSearchV1)
+		queryV1(w, r, true)
+	}
+}
+
+// explicitV1 - запрос назвал первую версию префиксом пути или в Accept
+func explicitV1(r *http.Request) bool {
+	if versionPrefix(r) == APIVersion1 {
+		return true
+	}
+	return negotiateAccept(r.Header.Get("Accept"), map[string]string{MimeSearchV1: APIVersion1}, "") == APIVersion1
+}
+
+// versionPrefix возвращает версию из префикса пути /v1 или /v2, пусто - префикса нет
+func versionPrefix(r *http.Request) string {
+	prefix := strings.TrimPrefix(r.URL.Path, "/")
+	if i := strings.Index(prefix, "/"); i >= 0 {
+		prefix = prefix[:i]
+	}
+	if prefix == APIVersion1 || prefix == APIVersion2 {
+		return prefix
+	}
+	return ""
+}
+
+func negotiateVersion(r *http.Request) string {
+	if prefix := versionPrefix(r); prefix != "" {
+		return prefix
+	}
+
+	return negotiateAccept(r.Header.Get("Accept"), map[string]string{
+		MimeSearchV1: APIVersion1,
+		MimeSearchV2: APIVersion2,
+	}, APIVersion1)
+}
+
+// negotiateAccept выбирает из заголовка Accept поддерживаемый тип с наибольшим q,
+// при равных q побеждает указанный раньше. supported сопоставляет mime-тип и результат
+func negotiateAccept(accept string, supported map[string]string, def string) string {
+	result, best := def, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+
+		candidate, ok := supported[mediaType]
+		if ok && q > best {
+			result, best = candidate, q
+		}
+	}
+	return result
+}
+
+// QueryV2 - вторая версия API: ответ в конверте с метаданными пагинации и структурированные ошибки
+func QueryV2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", MimeSearchV2)
+
+	if !authorized(r) {
+		writeErrorV2(w, http.StatusUnauthorized, SearchErrorDetail{Code: ErrorCodeBadAccessToken, Message: "bad access token"})
+		return
+	}
+
+	store, err := requestStore(r)
+	if err != nil {
+		writeErrorV2(w, http.StatusInternalServerError, SearchErrorDetail{Code: ErrorCodeInternal, Message: "dataset is unavailable"})
+		return
+	}
+
+	params, err := parseSearchParams(r)
+	if err == nil && params.Limit < 0 {
+		err = &paramError{Field: "limit", Err: errNegative}
+	}
+	if err == nil && params.Offset < 0 {
+		err = &paramError{Field: "offset", Err: errNegative}
+	}
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, errorDetailV2(err))
+		return
+	}
+
+	if notModified(w, r, store, APIVersion2, params) {
+		return
+	}
+
+	page, nextPage, err := searchPage(store, params)
+	if err != nil {
+		writeErrorV2(w, http.StatusInternalServerError, SearchErrorDetail{Code: ErrorCodeInternal, Message: "dataset is unavailable"})
+		return
+	}
+	total, err := store.CountUsers(params.Query)
+	if err != nil {
+		writeErrorV2(w, http.StatusInternalServerError, SearchErrorDetail{Code: ErrorCodeInternal, Message: "dataset is unavailable"})
+		return
+	}
+	result := SearchResponseV2{
+		Users: page,
+		Paging: SearchPaging{
+			Limit:    params.Limit,
+			Offset:   params.Offset,
+			Total:    total,
+			NextPage: nextPage,
+		},
+	}
+	if result.Users == nil {
+		result.Users = []User{}
+	}
+
+	data, _ := json.Marshal(result)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func errorDetailV2(err error) SearchErrorDetail {
+	if err == errBadOrderField {
+		return SearchErrorDetail{Code: ErrorCodeBadOrderField, Message: "order_field must be one of id, age, name", Field: "order_field"}
+	}
+	if verr, ok := err.(validationError); ok {
+		return SearchErrorDetail{Code: ErrorCodeBadParam, Message: "request body is invalid", Details: verr}
+	}
+	if perr, ok := err.(*paramError); ok {
+		return SearchErrorDetail{Code: ErrorCodeBadParam, Message: perr.Err.Error(), Field: perr.Field}
+	}
+	return SearchErrorDetail{Code: ErrorCodeBadParam, Message: err.Error()}
+}
+
+func writeErrorV2(w http.ResponseWriter, status int, detail SearchErrorDetail) {
+	data, _ := json.Marshal(SearchErrorResponseV2{Error: detail})
+	w.WriteHeader(status)
+	w.Write(data)
+}
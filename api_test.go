@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSearchServerVersions(t *testing.T) {
+	cases := []TestCase{
+		tResultCase1(),
+		tResultCase2(),
+		tResultCase3(),
+		tResultCase4(),
+		tResultCase5(),
+		tResultCase6(),
+		tResultCase7(),
+		tResultCase8(),
+		tResultCase9(),
+		tResultCase10(),
+		tResultCase11(),
+		tResultCase12(),
+		tResultCase13(),
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+
+	for _, version := range []string{"", APIVersion1, APIVersion2} {
+		for caseNum, item := range cases {
+			s := &SearchClient{
+				AccessToken: item.Token,
+				URL:         ts.URL,
+				Version:     version,
+			}
+			result, err := s.FindUsers(*item.Query)
+
+			if err != nil && !item.IsError {
+				t.Errorf("[%s/%d] unexpected error: %#v", version, caseNum, err)
+			}
+			if err == nil && item.IsError {
+				t.Errorf("[%s/%d] expected error, got nil", version, caseNum)
+			}
+
+			if !reflect.DeepEqual(item.Result, result) {
+				t.Errorf("[%s/%d] wrong result, expected %#v\n, got \n %#v", version, caseNum, item.Result, result)
+			}
+		}
+	}
+	ts.Close()
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	cases := []struct {
+		Path    string
+		Accept  string
+		Version string
+	}{
+		{"/", "", APIVersion1},
+		{"/", "application/json", APIVersion1},
+		{"/", MimeSearchV2, APIVersion2},
+		{"/", MimeSearchV2 + ", " + MimeSearchV1 + ";q=0.9", APIVersion2},
+		{"/", MimeSearchV2 + ";q=0.5, " + MimeSearchV1, APIVersion1},
+		{"/", MimeSearchV2 + ";q=0", APIVersion1},
+		{"/", MimeSearchV2 + ";q=abc", APIVersion1},
+		{"/v1", MimeSearchV2, APIVersion1},
+		{"/v2/", "", APIVersion2},
+		{"/v2/users", MimeSearchV1, APIVersion2},
+		{"/v3", "", APIVersion1},
+	}
+
+	for caseNum, item := range cases {
+		r := httptest.NewRequest("GET", item.Path, nil)
+		r.Header.Set("Accept", item.Accept)
+		if version := negotiateVersion(r); version != item.Version {
+			t.Errorf("[%d] wrong version for %s %q: expected %s, got %s", caseNum, item.Path, item.Accept, item.Version, version)
+		}
+	}
+}
+
+func TestSearchServerV2Envelope(t *testing.T) {
+	cases := []struct {
+		Query  string
+		Status int
+		Paging SearchPaging
+		Users  int
+		Error  SearchErrorDetail
+	}{
+		{"limit=3&offset=0&order_field=id", http.StatusOK, SearchPaging{Limit: 3, Offset: 0, Total: 35, NextPage: true}, 3, SearchErrorDetail{}},
+		{"limit=5&offset=33&order_field=id", http.StatusOK, SearchPaging{Limit: 5, Offset: 33, Total: 35, NextPage: false}, 2, SearchErrorDetail{}},
+		{"limit=5&query=Aguilar", http.StatusOK, SearchPaging{Limit: 5, Offset: 0, Total: 1, NextPage: false}, 1, SearchErrorDetail{}},
+		{"limit=5&query=nobody", http.StatusOK, SearchPaging{Limit: 5, Offset: 0, Total: 0, NextPage: false}, 0, SearchErrorDetail{}},
+		{"order_field=picture", http.StatusBadRequest, SearchPaging{}, 0, SearchErrorDetail{Code: ErrorCodeBadOrderField, Message: "order_field must be one of id, age, name", Field: "order_field"}},
+		{"limit=abc", http.StatusBadRequest, SearchPaging{}, 0, SearchErrorDetail{Code: ErrorCodeBadParam, Message: `strconv.Atoi: parsing "abc": invalid syntax`, Field: "limit"}},
+		{"limit=-1", http.StatusBadRequest, SearchPaging{}, 0, SearchErrorDetail{Code: ErrorCodeBadParam, Message: "must not be negative", Field: "limit"}},
+		{"offset=-1", http.StatusBadRequest, SearchPaging{}, 0, SearchErrorDetail{Code: ErrorCodeBadParam, Message: "must not be negative", Field: "offset"}},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+
+	for caseNum, item := range cases {
+		req, _ := http.NewRequest("GET", ts.URL+"/v2?"+item.Query, nil)
+		req.Header.Set("AccessToken", serverAccessToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] wrong status, expected %d, got %d", caseNum, item.Status, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != MimeSearchV2 {
+			t.Errorf("[%d] wrong content type %s", caseNum, ct)
+		}
+
+		if item.Status != http.StatusOK {
+			errResp := SearchErrorResponseV2{}
+			json.Unmarshal(body, &errResp)
+			if !reflect.DeepEqual(item.Error, errResp.Error) {
+				t.Errorf("[%d] wrong error, expected %#v, got %#v", caseNum, item.Error, errResp.Error)
+			}
+			continue
+		}
+
+		result := SearchResponseV2{}
+		json.Unmarshal(body, &result)
+		if result.Paging != item.Paging {
+			t.Errorf("[%d] wrong paging, expected %#v, got %#v", caseNum, item.Paging, result.Paging)
+		}
+		if result.Users == nil || len(result.Users) != item.Users {
+			t.Errorf("[%d] wrong users count, expected %d, got %#v", caseNum, item.Users, result.Users)
+		}
+	}
+	ts.Close()
+}
+
+func TestSearchClientVersionErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(QueryDummy))
+
+	s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Version: APIVersion2}
+	if _, err := s.FindUsers(SearchRequest{Limit: 1}); err == nil {
+		t.Errorf("expected error for v2 client against v1 server, got nil")
+	}
+
+	s = &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Version: "v9"}
+	if _, err := s.FindUsers(SearchRequest{Limit: 1}); err == nil {
+		t.Errorf("expected error for unknown version, got nil")
+	}
+	ts.Close()
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// политики выбора реплики
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceLeastOutstanding = "least-outstanding"
+
+	defaultEjectAfter = 3
+	defaultEjectFor   = 10 * time.Second
+)
+
+var errNoEndpoints = errors.New("load balancer has no endpoints")
+
+// LoadBalancer распределяет запросы SearchClient между репликами сервера поиска.
+// Реплика, которая ответила сбоем (ошибка соединения, таймаут или 5xx) EjectAfter раз подряд,
+// выводится из ротации на EjectFor. При сбое запрос повторяется на следующей реплике, пока они не кончатся.
+// Нулевое значение с заполненным URLs готово к работе
+type LoadBalancer struct {
+	// адреса реплик, как SearchClient.URL
+	URLs []string
+	// BalanceRoundRobin или BalanceLeastOutstanding, пусто - BalanceRoundRobin
+	Policy string
+	// сбоев подряд, после которых реплика выводится; 0 - defaultEjectAfter
+	EjectAfter int
+	// на сколько выводится реплика; 0 - defaultEjectFor
+	EjectFor time.Duration
+
+	mu        sync.Mutex
+	endpoints []endpointState
+	next      int
+	// часы, подменяются в тестах
+	now func() time.Time
+}
+
+type endpointState struct {
+	outstanding  int
+	failures     int
+	requests     int
+	errors       int
+	ejectedUntil time.Time
+}
+
+// EndpointStats - состояние реплики
+type EndpointStats struct {
+	URL string
+	// запросы, на которые реплика ещё не ответила
+	Outstanding int
+	// сбои подряд
+	Failures int
+	// всего запросов и сбоев
+	Requests int
+	Errors   int
+	// реплика выведена из ротации
+	Ejected bool
+}
+
+func (b *LoadBalancer) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+	return b.now()
+}
+
+// init выравнивает состояние реплик по URLs; вызывается под b.mu
+func (b *LoadBalancer) init() {
+	if len(b.endpoints) != len(b.URLs) {
+		b.endpoints = make([]endpointState, len(b.URLs))
+	}
+}
+
+// Endpoints возвращает состояние реплик в порядке URLs
+func (b *LoadBalancer) Endpoints() []EndpointStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	now := b.clock()
+	result := make([]EndpointStats, len(b.URLs))
+	for i, state := range b.endpoints {
+		result[i] = EndpointStats{
+			URL:         b.URLs[i],
+			Outstanding: state.outstanding,
+			Failures:    state.failures,
+			Requests:    state.requests,
+			Errors:      state.errors,
+			Ejected:     now.Before(state.ejectedUntil),
+		}
+	}
+	return result
+}
+
+// base - адрес, относительно которого SearchClient строит запросы; do переносит их на выбранную реплику
+func (b *LoadBalancer) base() string {
+	if len(b.URLs) == 0 {
+		return ""
+	}
+	return b.URLs[0]
+}
+
+// pick выбирает реплику среди ещё не опробованных и отмечает начало запроса к ней.
+// Выведенные реплики берутся, только если других не осталось
+func (b *LoadBalancer) pick(tried []bool) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	now := b.clock()
+
+	best := -1
+	for offset := range b.endpoints {
+		i := (b.next + offset) % len(b.endpoints)
+		if tried[i] {
+			continue
+		}
+		if best < 0 || b.better(i, best, now) {
+			best = i
+		}
+		// по кругу берётся первая реплика в ротации, остальные перебирать незачем
+		if b.Policy != BalanceLeastOutstanding && !now.Before(b.endpoints[best].ejectedUntil) {
+			break
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	b.next = (best + 1) % len(b.endpoints)
+	b.endpoints[best].outstanding++
+	b.endpoints[best].requests++
+	return best, true
+}
+
+// better сравнивает реплики i и j: работающая лучше выведенной, из выведенных лучше та, что вернётся раньше,
+// а из работающих при BalanceLeastOutstanding - та, у которой меньше незавершённых запросов; вызывается под b.mu
+func (b *LoadBalancer) better(i, j int, now time.Time) bool {
+	a, c := b.endpoints[i], b.endpoints[j]
+	aEjected, cEjected := now.Before(a.ejectedUntil), now.Before(c.ejectedUntil)
+	switch {
+	case aEjected != cEjected:
+		return !aEjected
+	case aEjected:
+		return a.ejectedUntil.Before(c.ejectedUntil)
+	}
+	return b.Policy == BalanceLeastOutstanding && a.outstanding < c.outstanding
+}
+
+// done отмечает конец запроса к реплике; failed - сбой реплики
+func (b *LoadBalancer) done(i int, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	state := &b.endpoints[i]
+	if state.outstanding > 0 {
+		state.outstanding--
+	}
+	if !failed {
+		state.failures = 0
+		return
+	}
+	state.errors++
+	state.failures++
+	ejectAfter := b.EjectAfter
+	if ejectAfter <= 0 {
+		ejectAfter = defaultEjectAfter
+	}
+	ejectFor := b.EjectFor
+	if ejectFor <= 0 {
+		ejectFor = defaultEjectFor
+	}
+	if state.failures >= ejectAfter {
+		state.ejectedUntil = b.clock().Add(ejectFor)
+	}
+}
+
+// do отправляет запрос, построенный относительно base(), на реплики по очереди, пока одна не ответит без сбоя.
+// Если сбоят все, возвращается результат последней
+func (b *LoadBalancer) do(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	if len(b.URLs) == 0 {
+		return nil, errNoEndpoints
+	}
+	tried := make([]bool, len(b.URLs))
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt < len(b.URLs); attempt++ {
+		i, ok := b.pick(tried)
+		if !ok {
+			break
+		}
+		tried[i] = true
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		var attemptReq *http.Request
+		attemptReq, err = rebase(req, b.base(), b.URLs[i])
+		if err != nil {
+			b.done(i, false)
+			return nil, err
+		}
+		resp, err = httpClient.Do(attemptReq)
+		if err != nil && req.Context().Err() != nil {
+			// отменил вызывающий, реплика не виновата
+			b.done(i, false)
+			return resp, err
+		}
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		b.done(i, failed)
+		if !failed {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+// rebase переносит запрос с адреса from на адрес to: путь запроса относительно базового пути from
+// дописывается к базовому пути to, параметры запроса сохраняются. Адреса сравниваются разобранными,
+// поэтому порт по умолчанию, слэш в конце и экранирование в них не мешают
+func rebase(req *http.Request, from, to string) (*http.Request, error) {
+	fromURL, err := url.Parse(from)
+	if err != nil {
+		return nil, err
+	}
+	toURL, err := url.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+	rest, ok := relativePath(req.URL.EscapedPath(), fromURL.EscapedPath())
+	if !ok {
+		return nil, fmt.Errorf("request path %s is outside of %s", req.URL.EscapedPath(), from)
+	}
+	escaped := strings.TrimSuffix(toURL.EscapedPath(), "/") + rest
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return nil, err
+	}
+
+	target := *req.URL
+	target.Scheme, target.Host, target.User = toURL.Scheme, toURL.Host, toURL.User
+	target.Path, target.RawPath = path, escaped
+	clone := req.Clone(req.Context())
+	clone.URL, clone.Host = &target, ""
+	if req.GetBody != nil {
+		if clone.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return clone, nil
+}
+
+// relativePath возвращает часть экранированного пути path после базового пути base, начиная со слэша
+func relativePath(path, base string) (string, bool) {
+	base = strings.TrimSuffix(base, "/")
+	if !strings.HasPrefix(path, base) {
+		return "", false
+	}
+	rest := path[len(base):]
+	if rest != "" && rest[0] != '/' {
+		// /api не должен совпадать с /apiv2
+		return "", false
+	}
+	return rest, true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newReplicas(t *testing.T, handlers ...http.Handler) []string {
+	urls := make([]string, len(handlers))
+	for i, handler := range handlers {
+		ts := httptest.NewServer(handler)
+		t.Cleanup(ts.Close)
+		urls[i] = ts.URL
+	}
+	return urls
+}
+
+// closedURL - адрес, на котором никто не слушает
+func closedURL() string {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	return ts.URL
+}
+
+func TestLoadBalancerRoundRobin(t *testing.T) {
+	replicas := []*flakyServer{{}, {}, {}}
+	urls := newReplicas(t, replicas[0], replicas[1], replicas[2])
+	client := &SearchClient{AccessToken: serverAccessToken, Balancer: &LoadBalancer{URLs: urls}}
+
+	for i := 0; i < 6; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	for i, replica := range replicas {
+		if requests := replica.set(false); requests != 2 {
+			t.Errorf("replica %d: expected 2 requests, got %d", i, requests)
+		}
+	}
+	count := 0
+	if err := client.StreamUsers(SearchRequest{}, func(User) error { count++; return nil }); err != nil || count != 35 {
+		t.Errorf("expected export through balancer, got %d %v", count, err)
+	}
+}
+
+func TestLoadBalancerFailover(t *testing.T) {
+	down, healthy := &flakyServer{down: true}, &flakyServer{}
+	urls := append(newReplicas(t, down, healthy), closedURL())
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	balancer := &LoadBalancer{URLs: urls, EjectAfter: 2, EjectFor: time.Minute, now: clock.Now}
+	client := &SearchClient{AccessToken: serverAccessToken, Balancer: balancer}
+
+	for i := 0; i < 6; i++ {
+		if result, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil || len(result.Users) != 1 {
+			t.Fatalf("request %d: expected failover to healthy replica, got %v %v", i, result, err)
+		}
+	}
+	stats := balancer.Endpoints()
+	if !stats[0].Ejected || stats[1].Ejected || !stats[2].Ejected || stats[0].Errors != 2 || stats[2].Errors != 2 {
+		t.Errorf("expected failing replicas to be ejected, got %+v", stats)
+	}
+
+	// выведенные реплики не получают запросов
+	before := down.set(true)
+	client.FindUsers(SearchRequest{Limit: 1})
+	if down.set(true) != before {
+		t.Errorf("ejected replica got a request")
+	}
+
+	// через EjectFor реплика возвращается в ротацию
+	down.set(false)
+	clock.now = clock.now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		client.FindUsers(SearchRequest{Limit: 1})
+	}
+	if down.set(false) == before {
+		t.Errorf("replica did not come back after EjectFor")
+	}
+	if stats := balancer.Endpoints(); stats[0].Ejected || stats[0].Failures != 0 {
+		t.Errorf("expected recovered replica, got %+v", stats[0])
+	}
+}
+
+func TestLoadBalancerAllDown(t *testing.T) {
+	urls := newReplicas(t, &flakyServer{down: true}, &flakyServer{down: true})
+	client := &SearchClient{AccessToken: serverAccessToken, Balancer: &LoadBalancer{URLs: urls}}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("expected last replica error, got %v", err)
+	}
+
+	empty := &SearchClient{AccessToken: serverAccessToken, Balancer: &LoadBalancer{}}
+	if _, err := empty.FindUsers(SearchRequest{Limit: 1}); err == nil || !strings.Contains(err.Error(), errNoEndpoints.Error()) {
+		t.Errorf("expected no endpoints error, got %v", err)
+	}
+}
+
+func TestLoadBalancerRetriesPostBody(t *testing.T) {
+	server, _ := newTestTenants(t)
+	urls := newReplicas(t, &flakyServer{down: true}, server)
+	client := &SearchClient{AccessToken: acmeToken, Tenant: "acme", Balancer: &LoadBalancer{URLs: []string{urls[0] + "/", urls[1]}}}
+
+	// длинный запрос уходит POST-ом, и на второй реплике тело должно быть тем же
+	query := strings.Repeat("x", maxQueryLength)
+	result, err := client.FindUsers(SearchRequest{Limit: 5, Query: query})
+	if err != nil || len(result.Users) != 0 {
+		t.Errorf("unexpected result %v %v", result, err)
+	}
+	if result, err := client.FindUsers(SearchRequest{Limit: 5, Query: "Boyd"}); err != nil || len(result.Users) != 1 {
+		t.Errorf("unexpected result %v %v", result, err)
+	}
+}
+
+func TestLoadBalancerLeastOutstanding(t *testing.T) {
+	balancer := &LoadBalancer{URLs: []string{"a", "b", "c"}, Policy: BalanceLeastOutstanding}
+	tried := make([]bool, 3)
+	first, _ := balancer.pick(tried)
+	second, _ := balancer.pick(tried)
+	balancer.done(first, false)
+	third, _ := balancer.pick(tried)
+	fourth, _ := balancer.pick(tried)
+	// после ответа a свободны a и c: сначала берётся c, следующая по кругу, а потом a
+	if first != 0 || second != 1 || third != 2 || fourth != 0 {
+		t.Errorf("unexpected picks %d %d %d %d", first, second, third, fourth)
+	}
+	if stats := balancer.Endpoints(); stats[0].Outstanding != 1 || stats[1].Outstanding != 1 || stats[2].Outstanding != 1 {
+		t.Errorf("unexpected outstanding %+v", stats)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	cases := []struct {
+		from, request, to, expected string
+	}{
+		{"http://a.local", "http://a.local/v2/users?limit=5", "http://b.local:8080", "http://b.local:8080/v2/users?limit=5"},
+		// базовый путь реплик
+		{"http://a.local/api", "http://a.local/api/v2/users?limit=5", "http://b.local/search/", "http://b.local/search/v2/users?limit=5"},
+		// явный порт по умолчанию и слэш в конце
+		{"http://a.local:80/api/", "http://a.local:80/api/v2/users", "https://b.local:443", "https://b.local:443/v2/users"},
+		// экранированные символы в пути и параметрах
+		{"http://a.local/a%20b", "http://a.local/a%20b/tenants/x%2Fy?query=%D1%8F+z", "http://b.local/c%3Fd", "http://b.local/c%3Fd/tenants/x%2Fy?query=%D1%8F+z"},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(http.MethodGet, c.request, nil)
+		if err != nil {
+			t.Fatalf("bad request %s: %s", c.request, err)
+		}
+		rebased, err := rebase(req, c.from, c.to)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", c.request, err)
+			continue
+		}
+		if rebased.URL.String() != c.expected {
+			t.Errorf("[%s] expected %s, got %s", c.request, c.expected, rebased.URL)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://a.local/apiv2/users", nil)
+	if _, err := rebase(req, "http://a.local/api", "http://b.local"); err == nil {
+		t.Errorf("expected error for request outside of the base path")
+	}
+}
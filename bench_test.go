@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	benchSizes     = flag.String("bench.sizes", "1000,10000", "размеры синтетического датасета для бенчмарков через запятую")
+	benchLarge     = flag.Bool("bench.large", false, "гонять бенчмарки на датасетах 10000, 100000 и 1000000 строк вместо -bench.sizes")
+	benchBaseline  = flag.String("bench.baseline", "testdata/bench_baseline.txt", "сохранённый вывод go test -bench, с которым идёт сравнение")
+	benchCurrent   = flag.String("bench.current", "", "свежий вывод go test -bench; если задан, TestBenchmarkRegression сравнивает его с базовым")
+	benchThreshold = flag.Float64("bench.threshold", 0.2, "допустимый рост метрики, 0.2 - 20%")
+)
+
+var (
+	benchDataMu sync.Mutex
+	benchData   = map[int][]byte{}
+)
+
+// benchDataset возвращает синтетический датасет из rows строк, сгенерированный один раз на прогон
+func benchDataset(b *testing.B, rows int) []byte {
+	benchDataMu.Lock()
+	defer benchDataMu.Unlock()
+	if data, ok := benchData[rows]; ok {
+		return data
+	}
+	buffer := &bytes.Buffer{}
+	if err := GenerateDataset(buffer, DatasetConfig{Rows: rows, Seed: 1}); err != nil {
+		b.Fatalf("cant generate dataset: %s", err)
+	}
+	benchData[rows] = buffer.Bytes()
+	return buffer.Bytes()
+}
+
+func benchUsers(b *testing.B, rows int) Users {
+	users, err := parseUsers(benchDataset(b, rows))
+	if err != nil {
+		b.Fatalf("cant parse dataset: %s", err)
+	}
+	return users
+}
+
+// largeBenchSizes - размеры для -bench.large: загрузка миллиона строк идёт минуты, поэтому по умолчанию они выключены
+const largeBenchSizes = "10000,100000,1000000"
+
+func forEachSize(b *testing.B, fn func(b *testing.B, rows int)) {
+	sizes := *benchSizes
+	if *benchLarge {
+		sizes = largeBenchSizes
+	}
+	for _, size := range strings.Split(sizes, ",") {
+		rows, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			b.Fatalf("bad -bench.sizes: %s", err)
+		}
+		b.Run(fmt.Sprintf("rows=%d", rows), func(b *testing.B) {
+			fn(b, rows)
+		})
+	}
+}
+
+func BenchmarkLoadUsers(b *testing.B) {
+	forEachSize(b, func(b *testing.B, rows int) {
+		data := benchDataset(b, rows)
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := parseUsers(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFilter(b *testing.B) {
+	forEachSize(b, func(b *testing.B, rows int) {
+		users := benchUsers(b, rows)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			users.FindUsers("Aguilar", "name", rows, 0, OrderByAsIs)
+		}
+	})
+}
+
+func BenchmarkSort(b *testing.B) {
+	forEachSize(b, func(b *testing.B, rows int) {
+		for _, field := range []string{"id", "age", "name"} {
+			b.Run("field="+field, func(b *testing.B) {
+				users := benchUsers(b, rows)
+				shuffled := append([]UserXml(nil), users.List...)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					copy(users.List, shuffled)
+					b.StartTimer()
+					users.FindUsers("", field, 26, 0, OrderByDesc)
+				}
+			})
+		}
+	})
+}
+
+func BenchmarkPaginate(b *testing.B) {
+	forEachSize(b, func(b *testing.B, rows int) {
+		users := benchUsers(b, rows)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			users.FindUsers("", "id", 26, rows/2, OrderByAsIs)
+		}
+	})
+}
+
+func BenchmarkSearchClientFindUsers(b *testing.B) {
+	forEachSize(b, func(b *testing.B, rows int) {
+		path := filepath.Join(b.TempDir(), "dataset.xml")
+		if err := ioutil.WriteFile(path, benchDataset(b, rows), 0644); err != nil {
+			b.Fatal(err)
+		}
+		defer func(previous string) { datasetPath = previous }(datasetPath)
+		datasetPath = path
+		// на больших размерах загрузка датасета дольше секундного таймаута клиента
+		defer func(previous time.Duration) { client.Timeout = previous }(client.Timeout)
+		client.Timeout = 0
+		// датасет загружается один раз на версию файла, в замер попадает только поиск
+		if _, _, err := openServerStore(); err != nil {
+			b.Fatal(err)
+		}
+
+		ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+		defer ts.Close()
+		s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.FindUsers(SearchRequest{Limit: 25, Offset: rows / 2, OrderField: "Name", OrderBy: OrderByAsc}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestBenchmarkRegression сравнивает свежие результаты бенчмарков с базовыми. Для сравнения ns/op
+// нужно хотя бы minTimeSamples прогонов в обоих файлах:
+//
+//	go test -run XXX -bench . -benchmem -count 10 > bench_output.txt
+//	go test -run TestBenchmarkRegression -bench.current bench_output.txt
+func TestBenchmarkRegression(t *testing.T) {
+	if *benchCurrent == "" {
+		t.Skip("-bench.current is not set")
+	}
+
+	load := func(path string) map[string]BenchResult {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("cant open %s: %s", path, err)
+		}
+		defer file.Close()
+		results, err := ParseBenchOutput(file)
+		if err != nil {
+			t.Fatalf("cant parse %s: %s", path, err)
+		}
+		return results
+	}
+
+	for _, regression := range CompareBenchmarks(load(*benchBaseline), load(*benchCurrent), *benchThreshold) {
+		t.Errorf("regression: %s", regression)
+	}
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+	baseline, err := ParseBenchOutput(strings.NewReader(`goos: linux
+BenchmarkFilter/rows=1000-8   	   10000	    100000 ns/op	    2000 B/op	      10 allocs/op
+BenchmarkSort/rows=1000/field=id-8   	   10000	    50000 ns/op	       0 B/op	       0 allocs/op
+BenchmarkSort/rows=1000/field=id-8   	   10000	    52000 ns/op	       0 B/op	       0 allocs/op
+BenchmarkSort/rows=1000/field=id-8   	   10000	    49000 ns/op	       0 B/op	       0 allocs/op
+BenchmarkSort/rows=1000/field=id-8   	   10000	    51000 ns/op	       0 B/op	       0 allocs/op
+BenchmarkSort/rows=1000/field=id-8   	   10000	    50500 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPaginate-8   	   10000	    1000 ns/op
+BenchmarkPaginate-8   	   10000	    1100 ns/op
+BenchmarkPaginate-8   	   10000	    1200 ns/op
+BenchmarkPaginate-8   	   10000	    1300 ns/op
+BenchmarkPaginate-8   	   10000	    3000 ns/op
+BenchmarkRemoved-8   	   10000	    50000 ns/op
+PASS
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if samples := baseline["BenchmarkSort/rows=1000/field=id"].NsPerOp; len(samples) != 5 {
+		t.Fatalf("expected 5 samples of repeated benchmark, got %v", samples)
+	}
+	current, err := ParseBenchOutput(strings.NewReader(`BenchmarkFilter/rows=1000-16   	   10000	    190000 ns/op	    3000 B/op	      10 allocs/op
+BenchmarkSort/rows=1000/field=id   	   10000	    90000 ns/op	      64 B/op	       1 allocs/op
+BenchmarkSort/rows=1000/field=id   	   10000	    91000 ns/op	      64 B/op	       1 allocs/op
+BenchmarkSort/rows=1000/field=id   	   10000	    88000 ns/op	      64 B/op	       1 allocs/op
+BenchmarkSort/rows=1000/field=id   	   10000	    92000 ns/op	      64 B/op	       1 allocs/op
+BenchmarkSort/rows=1000/field=id   	   10000	    89500 ns/op	      64 B/op	       1 allocs/op
+BenchmarkPaginate   	   10000	    900 ns/op
+BenchmarkPaginate   	   10000	    1500 ns/op
+BenchmarkPaginate   	   10000	    1600 ns/op
+BenchmarkPaginate   	   10000	    1700 ns/op
+BenchmarkPaginate   	   10000	    1150 ns/op
+BenchmarkAdded-8   	   10000	    50000 ns/op
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Filter: ns/op по одному замеру не сравнивается, B/op - сравнивается;
+	// Paginate: медиана выросла на 25%, но разброс не даёт отличить рост от шума
+	expected := []string{
+		"BenchmarkFilter/rows=1000 B/op: 2000 -> 3000 (+50.0%)",
+		"BenchmarkSort/rows=1000/field=id ns/op: 50500 -> 90000 (+78.2%, p=0.006)",
+	}
+	regressions := CompareBenchmarks(baseline, current, 0.2)
+	if len(regressions) != len(expected) {
+		t.Fatalf("expected %d regressions, got %v", len(expected), regressions)
+	}
+	for i, regression := range regressions {
+		if regression.String() != expected[i] {
+			t.Errorf("[%d] expected %q, got %q", i, expected[i], regression.String())
+		}
+	}
+
+	if _, err := ParseBenchOutput(strings.NewReader("BenchmarkBad-8 10 abc ns/op\n")); err == nil {
+		t.Errorf("expected error for bad value, got nil")
+	}
+}
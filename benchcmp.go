@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// меньше замеров времени на бенчмарк ns/op не сравнивается: по одному прогону шум не отличить от регрессии
+	minTimeSamples = 5
+	// уровень значимости, ниже которого рост ns/op считается регрессией
+	benchSignificance = 0.05
+)
+
+// BenchResult - замеры одного бенчмарка из вывода go test -bench -benchmem, по одному на прогон -count
+type BenchResult struct {
+	NsPerOp     []float64
+	BytesPerOp  []float64
+	AllocsPerOp []float64
+}
+
+// BenchRegression - метрика, которая ухудшилась сильнее допустимого. Baseline и Current - медианы замеров
+type BenchRegression struct {
+	Name     string
+	Metric   string
+	Baseline float64
+	Current  float64
+	// p-значение теста Манна-Уитни для ns/op; у B/op и allocs/op замеры не шумят, и оно не считается
+	P float64
+}
+
+func (r BenchRegression) String() string {
+	change := fmt.Sprintf("%s %s: %.0f -> %.0f (%+.1f%%", r.Name, r.Metric, r.Baseline, r.Current, (r.Current/r.Baseline-1)*100)
+	if r.P > 0 {
+		change += fmt.Sprintf(", p=%.3f", r.P)
+	}
+	return change + ")"
+}
+
+// ParseBenchOutput разбирает вывод go test -bench, повторы одного бенчмарка (-count) копятся как замеры.
+// Суффикс GOMAXPROCS (-8) отрезается от имени, чтобы результаты с разных машин можно было сравнивать
+func ParseBenchOutput(r io.Reader) (map[string]BenchResult, error) {
+	results := map[string]BenchResult{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		name := fields[0]
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			if _, err := strconv.Atoi(name[i+1:]); err == nil {
+				name = name[:i]
+			}
+		}
+
+		result := results[name]
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad value %q in line %q", fields[i], scanner.Text())
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				result.NsPerOp = append(result.NsPerOp, value)
+			case "B/op":
+				result.BytesPerOp = append(result.BytesPerOp, value)
+			case "allocs/op":
+				result.AllocsPerOp = append(result.AllocsPerOp, value)
+			}
+		}
+		results[name] = result
+	}
+	return results, scanner.Err()
+}
+
+// CompareBenchmarks возвращает метрики, медиана которых выросла больше чем на threshold (0.2 - на 20%).
+// B/op и allocs/op от прогона к прогону почти не меняются и сравниваются по медианам. ns/op шумит,
+// поэтому его рост считается регрессией, только если замеров в обоих наборах не меньше minTimeSamples
+// и односторонний тест Манна-Уитни отличает их с p < benchSignificance, как это делает benchstat.
+// Бенчмарки, которых нет в одном из наборов, не сравниваются
+func CompareBenchmarks(baseline, current map[string]BenchResult, threshold float64) []BenchRegression {
+	var regressions []BenchRegression
+	for name, cur := range current {
+		base, ok := baseline[name]
+		if !ok {
+			continue
+		}
+		metrics := []struct {
+			name      string
+			base, cur []float64
+			noisy     bool
+		}{
+			{"ns/op", base.NsPerOp, cur.NsPerOp, true},
+			{"B/op", base.BytesPerOp, cur.BytesPerOp, false},
+			{"allocs/op", base.AllocsPerOp, cur.AllocsPerOp, false},
+		}
+		for _, metric := range metrics {
+			if len(metric.base) == 0 || len(metric.cur) == 0 {
+				continue
+			}
+			regression := BenchRegression{Name: name, Metric: metric.name, Baseline: median(metric.base), Current: median(metric.cur)}
+			if regression.Baseline <= 0 || regression.Current <= regression.Baseline*(1+threshold) {
+				continue
+			}
+			if metric.noisy {
+				if len(metric.base) < minTimeSamples || len(metric.cur) < minTimeSamples {
+					continue
+				}
+				if regression.P = mannWhitneyGreater(metric.base, metric.cur); regression.P >= benchSignificance {
+					continue
+				}
+			}
+			regressions = append(regressions, regression)
+		}
+	}
+	sort.Slice(regressions, func(i, j int) bool {
+		if regressions[i].Name != regressions[j].Name {
+			return regressions[i].Name < regressions[j].Name
+		}
+		return regressions[i].Metric < regressions[j].Metric
+	})
+	return regressions
+}
+
+func median(samples []float64) float64 {
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// mannWhitneyGreater возвращает p-значение гипотезы, что замеры current больше замеров baseline.
+// Используется нормальное приближение U-статистики с поправками на связанные ранги и непрерывность
+func mannWhitneyGreater(baseline, current []float64) float64 {
+	type sample struct {
+		value   float64
+		current bool
+	}
+	all := make([]sample, 0, len(baseline)+len(current))
+	for _, value := range baseline {
+		all = append(all, sample{value: value})
+	}
+	for _, value := range current {
+		all = append(all, sample{value: value, current: true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// ранги с 1, одинаковым значениям - средний ранг группы
+	rankSum, ties := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].current {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(current)), float64(len(baseline))
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	variance := n1 * n2 / 12 * (n + 1 - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (u - n1*n2/2 - 0.5) / math.Sqrt(variance)
+	return math.Erfc(z/math.Sqrt2) / 2
+}
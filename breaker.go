@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerWindow   = 20
+	defaultBreakerTimeout  = 5 * time.Second
+)
+
+// BreakerState - состояние CircuitBreaker
+type BreakerState int
+
+const (
+	// запросы идут на сервер, неудачи считаются
+	BreakerClosed BreakerState = iota
+	// запросы сразу завершаются ErrCircuitOpen
+	BreakerOpen
+	// пропускаются только пробные запросы: удача закрывает цепь, неудача снова открывает
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ErrCircuitOpen - запрос не отправлялся, потому что цепь разомкнута. Конкретная ошибка - *CircuitOpenError
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError возвращается вместо запроса, пока цепь разомкнута
+type CircuitOpenError struct {
+	// через сколько цепь пропустит пробный запрос
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrCircuitOpen, e.RetryAfter)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreaker - предохранитель для SearchClient. Неудачей считается ошибка соединения, таймаут и ответ 5xx,
+// ответы 4xx - нормальная работа сервера. Цепь размыкается после ConsecutiveFailures неудач подряд
+// или если среди последних Window запросов доля неудач не меньше FailureRate. Через OpenTimeout
+// пропускаются HalfOpenRequests пробных запросов, и первый же результат решает, замкнуть цепь или нет.
+// Нулевое значение готово к работе, один CircuitBreaker можно делить между клиентами одного сервера
+type CircuitBreaker struct {
+	// неудач подряд, после которых цепь размыкается; 0 - defaultBreakerFailures, -1 - не учитывать
+	ConsecutiveFailures int
+	// доля неудач среди последних Window запросов, после которой цепь размыкается; 0 - не учитывать
+	FailureRate float64
+	// сколько последних запросов учитывается для FailureRate; 0 - defaultBreakerWindow
+	Window int
+	// сколько цепь остаётся разомкнутой до пробного запроса; 0 - defaultBreakerTimeout
+	OpenTimeout time.Duration
+	// сколько пробных запросов пропускается одновременно; 0 - 1
+	HalfOpenRequests int
+	// вызывается при смене состояния под блокировкой, поэтому не должна обращаться к CircuitBreaker
+	OnStateChange func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	// результаты последних запросов по кругу, true - неудача
+	window   []bool
+	next     int
+	recorded int
+	openedAt time.Time
+	probes   int
+	// номер текущего состояния: результаты запросов, начатых в прошлом состоянии, не учитываются
+	generation uint64
+	stats      BreakerStats
+	// часы, подменяются в тестах
+	now func() time.Time
+}
+
+// BreakerStats - состояние и счётчики предохранителя
+type BreakerStats struct {
+	State BreakerState `json:"state"`
+	// неудач подряд в замкнутом состоянии
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// доля неудач среди учтённых последних запросов
+	FailureRate float64 `json:"failure_rate"`
+	// когда цепь разомкнулась последний раз
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+	// запросы, отклонённые без отправки
+	Rejected int `json:"rejected"`
+	// сколько раз цепь размыкалась
+	Trips int `json:"trips"`
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+	return b.now()
+}
+
+func (b *CircuitBreaker) openTimeout() time.Duration {
+	if b.OpenTimeout <= 0 {
+		return defaultBreakerTimeout
+	}
+	return b.OpenTimeout
+}
+
+// State возвращает текущее состояние; разомкнутая цепь, у которой вышел OpenTimeout, считается полуоткрытой
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// Stats возвращает состояние и счётчики
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	stats := b.stats
+	stats.State, stats.ConsecutiveFailures, stats.OpenedAt = b.state, b.failures, b.openedAt
+	if b.recorded > 0 {
+		failed := 0
+		for _, failure := range b.window[:b.recorded] {
+			if failure {
+				failed++
+			}
+		}
+		stats.FailureRate = float64(failed) / float64(b.recorded)
+	}
+	return stats
+}
+
+// Reset замыкает цепь и сбрасывает статистику неудач
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setState(BreakerClosed)
+}
+
+// ServeHTTP отдаёт Stats в JSON для проверки здоровья: 200, пока цепь не разомкнута, иначе 503
+func (b *CircuitBreaker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats := b.Stats()
+	data, _ := json.Marshal(stats)
+	w.Header().Set("Content-Type", "application/json")
+	if stats.State == BreakerOpen {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(data)
+}
+
+// allow решает, можно ли отправить запрос, и возвращает номер состояния для record
+func (b *CircuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+
+	switch b.state {
+	case BreakerOpen:
+		b.stats.Rejected++
+		return 0, &CircuitOpenError{RetryAfter: b.openedAt.Add(b.openTimeout()).Sub(b.clock())}
+	case BreakerHalfOpen:
+		limit := b.HalfOpenRequests
+		if limit <= 0 {
+			limit = 1
+		}
+		if b.probes >= limit {
+			b.stats.Rejected++
+			return 0, &CircuitOpenError{}
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// record учитывает результат запроса, разрешённого allow
+func (b *CircuitBreaker) record(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	if b.state == BreakerHalfOpen {
+		if failed {
+			b.trip()
+		} else {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	if failed {
+		b.failures++
+	} else {
+		b.failures = 0
+	}
+	size := b.Window
+	if size <= 0 {
+		size = defaultBreakerWindow
+	}
+	if len(b.window) != size {
+		b.window, b.next, b.recorded = make([]bool, size), 0, 0
+	}
+	b.window[b.next] = failed
+	b.next = (b.next + 1) % size
+	if b.recorded < size {
+		b.recorded++
+	}
+
+	threshold := b.ConsecutiveFailures
+	if threshold == 0 {
+		threshold = defaultBreakerFailures
+	}
+	if threshold > 0 && b.failures >= threshold {
+		b.trip()
+		return
+	}
+	if b.FailureRate > 0 && b.recorded == size {
+		failed := 0
+		for _, failure := range b.window {
+			if failure {
+				failed++
+			}
+		}
+		if float64(failed)/float64(size) >= b.FailureRate {
+			b.trip()
+		}
+	}
+}
+
+// release возвращает пробный слот запроса, результат которого ничего не говорит о сервере, например отменённого
+func (b *CircuitBreaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// advance переводит разомкнутую цепь в полуоткрытое состояние по истечении OpenTimeout; вызывается под b.mu
+func (b *CircuitBreaker) advance() {
+	if b.state == BreakerOpen && !b.clock().Before(b.openedAt.Add(b.openTimeout())) {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) trip() {
+	b.openedAt = b.clock()
+	b.stats.Trips++
+	b.setState(BreakerOpen)
+}
+
+// setState меняет состояние и начинает отсчёт заново; вызывается под b.mu
+func (b *CircuitBreaker) setState(state BreakerState) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probes, b.failures = 0, 0
+	b.window, b.next, b.recorded = nil, 0, 0
+	if from != state && b.OnStateChange != nil {
+		b.OnStateChange(from, state)
+	}
+}
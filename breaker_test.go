@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(b *CircuitBreaker) (*CircuitBreaker, *testClock, *[]string) {
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.now = clock.Now
+	transitions := &[]string{}
+	b.OnStateChange = func(from, to BreakerState) {
+		*transitions = append(*transitions, from.String()+">"+to.String())
+	}
+	return b, clock, transitions
+}
+
+// attempt проводит через предохранитель запрос с заданным исходом
+func attempt(b *CircuitBreaker, failed bool) error {
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+	b.record(generation, failed)
+	return nil
+}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	breaker, clock, transitions := newTestBreaker(&CircuitBreaker{ConsecutiveFailures: 3, OpenTimeout: time.Minute})
+
+	attempt(breaker, true)
+	attempt(breaker, true)
+	attempt(breaker, false)
+	attempt(breaker, true)
+	attempt(breaker, true)
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("success must reset consecutive failures")
+	}
+	attempt(breaker, true)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("expected open breaker")
+	}
+
+	clock.now = clock.now.Add(20 * time.Second)
+	err := attempt(breaker, false)
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || !errors.Is(err, ErrCircuitOpen) || open.RetryAfter != 40*time.Second {
+		t.Errorf("expected CircuitOpenError with RetryAfter, got %v", err)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open breaker")
+	}
+	probe, err := breaker.allow()
+	if err != nil {
+		t.Fatalf("expected probe, got %s", err)
+	}
+	if _, err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("only one probe must pass, got %v", err)
+	}
+	breaker.record(probe, true)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("failed probe must open breaker")
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	attempt(breaker, false)
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("successful probe must close breaker")
+	}
+
+	expected := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(*transitions) != len(expected) {
+		t.Fatalf("unexpected transitions %v", *transitions)
+	}
+	for i := range expected {
+		if (*transitions)[i] != expected[i] {
+			t.Errorf("unexpected transitions %v", *transitions)
+			break
+		}
+	}
+	if stats := breaker.Stats(); stats.Trips != 2 || stats.Rejected != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	breaker, _, _ := newTestBreaker(&CircuitBreaker{ConsecutiveFailures: -1, FailureRate: 0.5, Window: 4})
+
+	for _, failed := range []bool{true, false, true} {
+		attempt(breaker, failed)
+	}
+	if stats := breaker.Stats(); stats.State != BreakerClosed || stats.FailureRate < 0.66 {
+		t.Fatalf("window is not full yet, got %+v", stats)
+	}
+	attempt(breaker, false)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("expected open breaker at 50%% failures")
+	}
+}
+
+func TestCircuitBreakerStaleResults(t *testing.T) {
+	breaker, clock, _ := newTestBreaker(&CircuitBreaker{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+
+	// запрос начат до размыкания и закончился после: его результат не должен замкнуть цепь
+	slow, _ := breaker.allow()
+	attempt(breaker, true)
+	breaker.record(slow, false)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("stale result changed breaker state")
+	}
+
+	// отменённый пробный запрос освобождает место для следующего
+	clock.now = clock.now.Add(time.Second)
+	probe, _ := breaker.allow()
+	breaker.release(probe)
+	if _, err := breaker.allow(); err != nil {
+		t.Errorf("expected new probe after release, got %s", err)
+	}
+
+	breaker.Reset()
+	if breaker.State() != BreakerClosed {
+		t.Errorf("expected closed breaker after Reset")
+	}
+}
+
+// flakyServer - SearchServer, который отвечает 500, пока down
+type flakyServer struct {
+	mu       sync.Mutex
+	down     bool
+	requests int
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	down := s.down
+	s.mu.Unlock()
+	if down {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	SearchServer(w, r)
+}
+
+func (s *flakyServer) set(down bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+	return s.requests
+}
+
+func TestSearchClientCircuitBreaker(t *testing.T) {
+	server := &flakyServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	breaker, clock, _ := newTestBreaker(&CircuitBreaker{ConsecutiveFailures: 3, OpenTimeout: time.Minute})
+	client := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Breaker: breaker}
+
+	// ошибки запроса - не сбой сервера
+	for i := 0; i < 5; i++ {
+		client.FindUsers(SearchRequest{OrderField: "bad"})
+	}
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("4xx responses must not open breaker")
+	}
+
+	server.set(true)
+	for i := 0; i < 3; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("expected server error, got %v", err)
+		}
+	}
+	requests := server.set(false)
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if err := client.StreamUsers(SearchRequest{}, func(User) error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen from export, got %v", err)
+	}
+	if server.set(false) != requests {
+		t.Errorf("open breaker must not send requests")
+	}
+
+	recorder := httptest.NewRecorder()
+	breaker.ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	stats := map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &stats)
+	if recorder.Code != http.StatusServiceUnavailable || stats["state"] != "open" {
+		t.Errorf("unexpected health response %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil || breaker.State() != BreakerClosed {
+		t.Errorf("expected successful probe, got %v %s", err, breaker.State())
+	}
+}
+
+func TestSearchClientCircuitBreakerConnectionErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	url := ts.URL
+	ts.Close()
+
+	breaker := &CircuitBreaker{ConsecutiveFailures: 2}
+	client := &SearchClient{AccessToken: serverAccessToken, URL: url, Breaker: breaker}
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 1})
+	start := time.Now()
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrCircuitOpen) || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("expected fast ErrCircuitOpen, got %v", err)
+	}
+}
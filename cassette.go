@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// CassetteMode - режим работы CassetteTransport
+type CassetteMode int
+
+const (
+	// ModeRecord - запросы уходят в сеть, пары запрос/ответ дописываются в кассету
+	ModeRecord CassetteMode = iota
+	// ModeReplay - ответы берутся из кассеты, сеть не используется
+	ModeReplay
+)
+
+// значение, которым заменяются скрытые заголовки в кассете
+const redacted = "REDACTED"
+
+// RecordedRequest - запрос в кассете. Хост не записывается, чтобы кассета не зависела от адреса сервера
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URI    string      `json:"uri"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+// Interaction - одна строка кассеты
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// CassetteTransport - http.RoundTripper для SearchClient.Transport, который записывает трафик
+// в JSONL-кассету или проигрывает его из неё. Запросы сопоставляются по методу, пути с параметрами и телу.
+// В режиме проигрывания запрос без записи в кассете завершается ошибкой
+type CassetteTransport struct {
+	Path string
+	Mode CassetteMode
+	// транспорт для записи, по умолчанию http.DefaultTransport
+	Transport http.RoundTripper
+	// заголовки, которые не попадают в кассету; по умолчанию AccessToken и Authorization
+	RedactHeaders []string
+
+	mu           sync.Mutex
+	loaded       bool
+	interactions []Interaction
+	used         []bool
+	unmatched    []RecordedRequest
+}
+
+// NewReplayer читает кассету сразу, чтобы отсутствующий или битый файл был виден до первого запроса
+func NewReplayer(path string) (*CassetteTransport, error) {
+	cassette := &CassetteTransport{Path: path, Mode: ModeReplay}
+	if err := cassette.load(); err != nil {
+		return nil, err
+	}
+	return cassette, nil
+}
+
+// Unmatched возвращает запросы, для которых в кассете не нашлось ответа
+func (c *CassetteTransport) Unmatched() []RecordedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]RecordedRequest(nil), c.unmatched...)
+}
+
+func (c *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, err := c.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.Mode == ModeReplay {
+		return c.replay(req, recorded)
+	}
+	return c.record(req, recorded)
+}
+
+func (c *CassetteTransport) recordRequest(req *http.Request) (RecordedRequest, error) {
+	recorded := RecordedRequest{
+		Method: req.Method,
+		URI:    req.URL.RequestURI(),
+		Header: c.redact(req.Header),
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return recorded, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		recorded.Body = string(body)
+	}
+	return recorded, nil
+}
+
+func (c *CassetteTransport) redact(header http.Header) http.Header {
+	names := c.RedactHeaders
+	if names == nil {
+		names = []string{"AccessToken", "Authorization"}
+	}
+	header = header.Clone()
+	for _, name := range names {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+	return header
+}
+
+func (c *CassetteTransport) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// кассета хранит тело строкой, поэтому сжатый ответ записывается распакованным
+	body, err := readBody(resp)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	line, err := json.Marshal(Interaction{
+		Request:  recorded,
+		Response: RecordedResponse{Status: resp.StatusCode, Header: c.redact(resp.Header), Body: string(body)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file, err := os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: %s", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("cassette: %s", err)
+	}
+	return resp, nil
+}
+
+func (c *CassetteTransport) load() error {
+	if c.loaded {
+		return nil
+	}
+	file, err := os.Open(c.Path)
+	if err != nil {
+		return fmt.Errorf("cassette: %s", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxSearchBodySize*4)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		interaction := Interaction{}
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return fmt.Errorf("cassette %s:%d: %s", c.Path, lineNum, err)
+		}
+		c.interactions = append(c.interactions, interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cassette: %s", err)
+	}
+	c.used = make([]bool, len(c.interactions))
+	c.loaded = true
+	return nil
+}
+
+// replay отдаёт первую неиспользованную подходящую запись, а если все использованы - последнюю подходящую
+func (c *CassetteTransport) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	found := -1
+	for i, interaction := range c.interactions {
+		if interaction.Request.Method != recorded.Method || interaction.Request.URI != recorded.URI || interaction.Request.Body != recorded.Body {
+			continue
+		}
+		found = i
+		if !c.used[i] {
+			break
+		}
+	}
+	if found < 0 {
+		c.unmatched = append(c.unmatched, recorded)
+		return nil, fmt.Errorf("cassette %s has no response for %s %s", c.Path, recorded.Method, recorded.URI)
+	}
+	c.used[found] = true
+
+	response := c.interactions[found].Response
+	header := response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:    response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(response.Body))),
+		ContentLength: int64(len(response.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	cases := []TestCase{
+		tResultCase1(),
+		tResultCase3(),
+		tResultCase6(),
+		tResultCase12(),
+		tResultCase13(),
+	}
+
+	path := filepath.Join(t.TempDir(), "search.jsonl")
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	recorder := &CassetteTransport{Path: path, Mode: ModeRecord}
+
+	for caseNum, item := range cases {
+		s := &SearchClient{AccessToken: item.Token, URL: ts.URL, Transport: recorder}
+		if _, err := s.FindUsers(*item.Query); (err != nil) != item.IsError {
+			t.Errorf("[%d] unexpected error while recording: %#v", caseNum, err)
+		}
+	}
+	s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Transport: recorder}
+	if err := s.StreamUsers(SearchRequest{Query: "Aguilar"}, func(User) error { return nil }); err != nil {
+		t.Errorf("unexpected error while recording stream: %#v", err)
+	}
+	ts.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), serverAccessToken) || strings.Contains(string(data), "iasdasdas") {
+		t.Errorf("cassette contains access token: %s", data)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != len(cases)+1 {
+		t.Errorf("expected %d interactions, got %d", len(cases)+1, lines)
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatalf("cant load cassette: %s", err)
+	}
+	for caseNum, item := range cases {
+		s := &SearchClient{AccessToken: item.Token, URL: "http://search.invalid", Transport: replayer}
+		result, err := s.FindUsers(*item.Query)
+
+		if err != nil && !item.IsError {
+			t.Errorf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%d] expected error, got nil", caseNum)
+		}
+		if !reflect.DeepEqual(item.Result, result) {
+			t.Errorf("[%d] wrong result, expected %#v\n, got \n %#v", caseNum, item.Result, result)
+		}
+	}
+
+	s = &SearchClient{AccessToken: serverAccessToken, URL: "http://search.invalid", Transport: replayer}
+	ids := []int{}
+	if err := s.StreamUsers(SearchRequest{Query: "Aguilar"}, func(user User) error { ids = append(ids, user.Id); return nil }); err != nil || !reflect.DeepEqual(ids, []int{2}) {
+		t.Errorf("wrong replayed stream %v, %#v", ids, err)
+	}
+
+	if _, err := s.FindUsers(*tResultCase1().Query); err != nil {
+		t.Errorf("expected repeated request to be replayed, got %#v", err)
+	}
+	if _, err := s.FindUsers(SearchRequest{Limit: 7, Query: "never recorded"}); err == nil || !strings.Contains(err.Error(), "has no response for GET") {
+		t.Errorf("expected unmatched request error, got %#v", err)
+	}
+	if unmatched := replayer.Unmatched(); len(unmatched) != 1 || unmatched[0].Header.Get("AccessToken") != redacted {
+		t.Errorf("wrong unmatched requests %#v", unmatched)
+	}
+}
+
+func TestCassetteReplayErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewReplayer(filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Errorf("expected error for missing cassette, got nil")
+	}
+
+	broken := filepath.Join(dir, "broken.jsonl")
+	ioutil.WriteFile(broken, []byte("{\"request\":{}}\n\nnotajson\n"), 0644)
+	if _, err := NewReplayer(broken); err == nil || !strings.Contains(err.Error(), "broken.jsonl:3") {
+		t.Errorf("expected error with line number, got %#v", err)
+	}
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -39,6 +42,40 @@ type SearchErrorResponse struct {
 	Error string
 }
 
+// SearchPaging - метаданные пагинации в ответе второй версии API
+type SearchPaging struct {
+	Limit    int  `json:"limit"`
+	Offset   int  `json:"offset"`
+	Total    int  `json:"total"`
+	NextPage bool `json:"next_page"`
+}
+
+// SearchResponseV2 - конверт успешного ответа второй версии API
+type SearchResponseV2 struct {
+	Users  []User       `json:"users"`
+	Paging SearchPaging `json:"paging"`
+}
+
+// SearchErrorDetail - структурированная ошибка второй версии API
+type SearchErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// параметр запроса, к которому относится ошибка, если есть
+	Field string `json:"field,omitempty"`
+	// ошибки валидации по отдельным полям тела POST-запроса
+	Details []SearchFieldError `json:"details,omitempty"`
+}
+
+// SearchFieldError - ошибка валидации отдельного поля запроса
+type SearchFieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type SearchErrorResponseV2 struct {
+	Error SearchErrorDetail `json:"error"`
+}
+
 const (
 	OrderByAsc  = -1
 	OrderByAsIs = 0
@@ -47,6 +84,23 @@ const (
 	ErrorBadOrderField = `OrderField invalid`
 )
 
+const (
+	APIVersion1 = "v1"
+	APIVersion2 = "v2"
+
+	MimeSearchV1 = "application/vnd.search.v1+json"
+	MimeSearchV2 = "application/vnd.search.v2+json"
+
+	// коды ошибок второй версии API
+	ErrorCodeBadAccessToken = "ErrorBadAccessToken"
+	ErrorCodeBadOrderField  = "ErrorBadOrderField"
+	ErrorCodeBadParam       = "ErrorBadParam"
+	ErrorCodeInternal       = "ErrorInternal"
+
+	// длина урла, после которой клиент переходит с GET на POST с JSON-телом
+	maxQueryLength = 2048
+)
+
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
@@ -56,26 +110,130 @@ type SearchRequest struct {
 	OrderBy int
 }
 
+// UserSearcher - поиск пользователей независимо от транспорта.
+// Реализации: SearchClient (HTTP), GRPCSearchClient (gRPC) и FakeSearcher в тестах
+type UserSearcher interface {
+	// FindUsers возвращает одну страницу результатов, не больше 25 записей
+	FindUsers(req SearchRequest) (*SearchResponse, error)
+	// StreamUsers передаёт в fn все подходящие записи начиная с Offset, Limit не учитывается
+	StreamUsers(req SearchRequest, fn func(User) error) error
+}
+
+var _ UserSearcher = (*SearchClient)(nil)
+
 type SearchClient struct {
 	// токен, по которому происходит авторизация на внешней системе, уходит туда через хедер
 	AccessToken string
-	// урл внешней системы, куда идти
+	// урл внешней системы, куда идти; для нескольких реплик - Balancer
 	URL string
+	// версия API: APIVersion1, APIVersion2 или пусто - тогда версию выбирает сервер по заголовку Accept
+	Version string
+	// транспорт для запросов, по умолчанию http.DefaultTransport
+	Transport http.RoundTripper
+	// арендатор на сервере с несколькими датасетами (TenantServer); тогда URL - корень сервера
+	Tenant string
+	// кэш ответов FindUsers, nil - без кэша
+	Cache *ResponseCache
+	// не просить сервер сжимать ответы; по умолчанию клиент принимает все подключённые алгоритмы
+	DisableCompression bool
+	// объединение одинаковых одновременных вызовов FindUsers в один запрос, nil - каждый вызов идёт на сервер
+	Coalesce *Coalescer
+	// предохранитель: пока сервер недоступен, запросы сразу завершаются ErrCircuitOpen; nil - без него
+	Breaker *CircuitBreaker
+	// реплики сервера; если задан, URL не используется
+	Balancer *LoadBalancer
+	// дублирование медленных запросов FindUsers, nil - без дублей
+	Hedge *HedgePolicy
+	// получатель событий для метрик: попытки, повторы, таймауты, попадания в кэш; nil - без метрик
+	Metrics ClientMetrics
 }
 
-// FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
-func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+// target возвращает адрес поиска с учётом арендатора
+func (srv *SearchClient) target() string {
+	base := srv.URL
+	if srv.Balancer != nil {
+		base = srv.Balancer.base()
+	}
+	if srv.Tenant == "" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + tenantPathPrefix + url.PathEscape(srv.Tenant)
+}
 
-	searcherParams := url.Values{}
+// acceptEncoding просит сервер сжать ответ. Заголовок ставится явно, поэтому http.Transport
+// не распаковывает ответ сам и его распаковывает readBody
+func (srv *SearchClient) acceptEncoding(req *http.Request) {
+	if !srv.DisableCompression {
+		req.Header.Set("Accept-Encoding", acceptEncoding())
+	}
+}
 
+func (srv *SearchClient) httpClient() *http.Client {
+	if srv.Transport == nil && srv.Metrics == nil {
+		return client
+	}
+	return &http.Client{Timeout: client.Timeout, Transport: srv.roundTripper()}
+}
+
+func (srv *SearchClient) accept() (string, error) {
+	switch srv.Version {
+	case "":
+		return MimeSearchV2 + ", " + MimeSearchV1 + ";q=0.9", nil
+	case APIVersion1:
+		return MimeSearchV1, nil
+	case APIVersion2:
+		return MimeSearchV2, nil
+	}
+	return "", fmt.Errorf("unknown API version %s", srv.Version)
+}
+
+// normalizeRequest проверяет границы пагинации и ограничивает размер страницы 25 записями
+func normalizeRequest(req SearchRequest) (SearchRequest, error) {
 	if req.Limit < 0 {
-		return nil, fmt.Errorf("limit must be > 0")
+		return req, fmt.Errorf("limit must be > 0")
 	}
 	if req.Limit > 25 {
 		req.Limit = 25
 	}
 	if req.Offset < 0 {
-		return nil, fmt.Errorf("offset must be > 0")
+		return req, fmt.Errorf("offset must be > 0")
+	}
+	return req, nil
+}
+
+// FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
+func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext - FindUsers с отменой через ctx. Если запрос объединён с такими же (Coalesce),
+// отмена ctx прекращает только ожидание этого вызова, а общий запрос продолжается для остальных.
+// В сборке с тегом otel вызов пишет спан SearchClient.FindUsers, продолжая трассу из ctx
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	ctx, span := startClientSpan(ctx, "SearchClient.FindUsers")
+	defer span.End()
+	traceSearch(span, req)
+	result, err := srv.search(ctx, req)
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("search.result_count", len(result.Users))
+	}
+	return result, err
+}
+
+// search собирает запрос и отправляет его сам или через Coalesce
+func (srv *SearchClient) search(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+
+	searcherParams := url.Values{}
+
+	req, err := normalizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	accept, err := srv.accept()
+	if err != nil {
+		return nil, err
 	}
 
 	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
@@ -87,18 +245,108 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	searcherReq, err := newRequest(srv.target(), req, searcherParams)
+	if err != nil {
+		return nil, fmt.Errorf("cant build request: %s", err)
+	}
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	searcherReq.Header.Set("Accept", accept)
+	srv.acceptEncoding(searcherReq)
+	injectTraceContext(ctx, searcherReq.Header)
 
-	resp, err := client.Do(searcherReq)
+	// один ключ у кэша и у объединения запросов: одинаковые запросы дают одинаковый ответ
+	key := responseCacheKey(searcherReq.Method, searcherReq.URL.String(), accept, srv.AccessToken, req)
+	if srv.Coalesce == nil {
+		return srv.find(searcherReq.WithContext(ctx), key, searcherParams, req)
+	}
+	return srv.Coalesce.do(ctx, key, func(shared context.Context) (*SearchResponse, error) {
+		// общий запрос идёт в трассе первого вызова
+		return srv.find(searcherReq.WithContext(contextWithSpanFrom(shared, ctx)), key, searcherParams, req)
+	})
+}
+
+// find выполняет собранный запрос с учётом кэша ответов
+func (srv *SearchClient) find(searcherReq *http.Request, cacheKey string, searcherParams url.Values, req SearchRequest) (*SearchResponse, error) {
+	var cached *cacheEntry
+	if srv.Cache != nil {
+		if response, entry := srv.Cache.get(cacheKey); response != nil {
+			srv.metrics().CacheHit()
+			spanFromContext(searcherReq.Context()).SetAttribute("search.cache", "hit")
+			return response, nil
+		} else if entry != nil {
+			cached = entry
+			searcherReq.Header.Set("If-None-Match", entry.etag)
+		}
+	}
+
+	if srv.Metrics != nil {
+		searcherReq = searcherReq.WithContext(countAttempts(searcherReq.Context()))
+	}
+	resp, err := srv.send(srv.httpClient(), searcherReq, srv.Hedge)
 	if err != nil {
+		if ctxErr := searcherReq.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, ErrCircuitOpen) {
+			return nil, err
+		}
 		if err, ok := err.(net.Error); ok && err.Timeout() {
+			srv.metrics().Timeout()
 			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
 		}
 		return nil, fmt.Errorf("unknown error %s", err)
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	spanFromContext(searcherReq.Context()).SetAttribute("http.status_code", resp.StatusCode)
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		srv.metrics().CacheHit()
+		spanFromContext(searcherReq.Context()).SetAttribute("search.cache", "revalidated")
+		return srv.Cache.revalidated(cached, resp.Header), nil
+	}
+	result, err := srv.decodeResponse(resp, body, req)
+	if err == nil && srv.Cache != nil {
+		srv.Cache.put(cacheKey, result, resp.Header)
+	}
+	return result, err
+}
+
+// send отправляет запрос через предохранитель клиента, если он задан, и дублирует его по hedge, если она не nil
+func (srv *SearchClient) send(httpClient *http.Client, req *http.Request, hedge *HedgePolicy) (*http.Response, error) {
+	if srv.Breaker == nil {
+		return srv.hedgedRoundTrip(httpClient, req, hedge)
+	}
+	generation, err := srv.Breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := srv.hedgedRoundTrip(httpClient, req, hedge)
+	if err != nil && req.Context().Err() != nil {
+		// вызывающий сам отменил запрос, о сервере это ничего не говорит
+		srv.Breaker.release(generation)
+		return resp, err
+	}
+	srv.Breaker.record(generation, err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
+
+// roundTrip отправляет запрос на URL или, если задан Balancer, на одну из реплик
+func (srv *SearchClient) roundTrip(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	if srv.Balancer == nil {
+		return httpClient.Do(req)
+	}
+	return srv.Balancer.do(httpClient, req)
+}
+
+// decodeResponse разбирает ответ сервера любой версии API
+func (srv *SearchClient) decodeResponse(resp *http.Response, body []byte, req SearchRequest) (*SearchResponse, error) {
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == MimeSearchV2 {
+		return decodeV2(resp.StatusCode, body, req)
+	}
 
 	switch resp.StatusCode {
 	case http.StatusUnauthorized:
@@ -107,7 +355,7 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		return nil, fmt.Errorf("SearchServer fatal error")
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
-		err = json.Unmarshal(body, &errResp)
+		err := json.Unmarshal(body, &errResp)
 		if err != nil {
 			return nil, fmt.Errorf("cant unpack error json: %s", err)
 		}
@@ -117,21 +365,85 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
 	}
 
+	if srv.Version == APIVersion2 {
+		return nil, fmt.Errorf("server does not support API %s", APIVersion2)
+	}
+
 	data := []User{}
-	err = json.Unmarshal(body, &data)
+	err := json.Unmarshal(body, &data)
 	if err != nil {
 		return nil, fmt.Errorf("cant unpack result json: %s", err)
 	}
 
-	result := SearchResponse{}
 	//fmt.Println(len(data))
 	//fmt.Println(req.Limit)
-	if len(data) == req.Limit {
+	return pageResult(data, req.Limit), err
+}
+
+// newRequest собирает GET-запрос, а если параметры не помещаются в строку запроса - POST с JSON-телом
+func newRequest(target string, req SearchRequest, params url.Values) (*http.Request, error) {
+	query := params.Encode()
+	if len(target)+1+len(query) <= maxQueryLength {
+		return http.NewRequest("GET", target+"?"+query, nil)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	searcherReq, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	searcherReq.Header.Set("Content-Type", "application/json")
+	return searcherReq, nil
+}
+
+// pageResult отрезает лишнюю запись, запрошенную для определения следующей страницы
+func pageResult(data []User, limit int) *SearchResponse {
+	result := SearchResponse{}
+	if len(data) == limit {
 		result.NextPage = true
 		result.Users = data[0 : len(data)-1]
 	} else {
 		result.Users = data[0:len(data)]
 	}
+	return &result
+}
 
-	return &result, err
+// decodeV2 разбирает ответ второй версии API
+func decodeV2(status int, body []byte, req SearchRequest) (*SearchResponse, error) {
+	if status == http.StatusOK {
+		data := SearchResponseV2{}
+		err := json.Unmarshal(body, &data)
+		if err != nil {
+			return nil, fmt.Errorf("cant unpack result json: %s", err)
+		}
+		return pageResult(data.Users, req.Limit), nil
+	}
+
+	errResp := SearchErrorResponseV2{}
+	err := json.Unmarshal(body, &errResp)
+	if err != nil {
+		return nil, fmt.Errorf("cant unpack error json: %s", err)
+	}
+	switch errResp.Error.Code {
+	case ErrorCodeBadAccessToken:
+		return nil, fmt.Errorf("Bad AccessToken")
+	case ErrorCodeBadOrderField:
+		return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+	case ErrorCodeInternal:
+		return nil, fmt.Errorf("SearchServer fatal error")
+	}
+	if len(errResp.Error.Details) > 0 {
+		details := make([]string, 0, len(errResp.Error.Details))
+		for _, detail := range errResp.Error.Details {
+			details = append(details, detail.Field+": "+detail.Message)
+		}
+		return nil, fmt.Errorf("%s: %s (%s)", errResp.Error.Code, errResp.Error.Message, strings.Join(details, "; "))
+	}
+	if errResp.Error.Field != "" {
+		return nil, fmt.Errorf("%s: %s (%s)", errResp.Error.Code, errResp.Error.Message, errResp.Error.Field)
+	}
+	return nil, fmt.Errorf("%s: %s", errResp.Error.Code, errResp.Error.Message)
 }
@@ -1,18 +1,10 @@
 package main
 
 import (
-	"encoding/json"
-	"encoding/xml"
-	"errors"
 	_ "fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
-	"sort"
-	"strconv"
-	"strings"
 	"testing"
 	"time"
 )
@@ -24,259 +16,6 @@ type TestCase struct {
 	IsError bool
 }
 
-type UserXml struct {
-	ID        int    `xml:"id"`
-	GUID      string `xml:"guid"`
-	Active    bool   `xml:"isActive"`
-	Balance   string `xml:"balance"`
-	Picture   string `xml:"picture"`
-	Age       int    `xml:"age"`
-	EyeColor  string `xml:"eyeColor"`
-	FirstName string `xml:"first_name"`
-	LastName  string `xml:"last_name"`
-	Gender    string `xml:"gender"`
-	Company   string `xml:"company"`
-	Email     string `xml:"email"`
-	Phone     string `xml:"phone"`
-	Address   string `xml:"address"`
-	About     string `xml:"about"`
-}
-
-// Name sort
-type UserNameSort []UserXml
-
-func (slice UserNameSort) Len() int {
-	return len(slice)
-}
-
-func (slice UserNameSort) Swap(i, j int) {
-	slice[i], slice[j] = slice[j], slice[i]
-}
-
-func (slice UserNameSort) Less(i, j int) bool {
-	return slice[i].FirstName+" "+slice[i].LastName < slice[j].FirstName+" "+slice[j].LastName
-}
-
-// Age sort
-type UserAgeSort []UserXml
-
-func (slice UserAgeSort) Len() int {
-	return len(slice)
-}
-
-func (slice UserAgeSort) Swap(i, j int) {
-	slice[i], slice[j] = slice[j], slice[i]
-}
-
-func (slice UserAgeSort) Less(i, j int) bool {
-	return slice[i].Age < slice[j].Age
-}
-
-// Id sort
-type UserIdSOrt []UserXml
-
-func (slice UserIdSOrt) Len() int {
-	return len(slice)
-}
-
-func (slice UserIdSOrt) Swap(i, j int) {
-	slice[i], slice[j] = slice[j], slice[i]
-}
-
-func (slice UserIdSOrt) Less(i, j int) bool {
-	return slice[i].ID < slice[j].ID
-}
-
-type Users struct {
-	List []UserXml `xml:"row"`
-}
-
-func (usr *Users) FindUsers(query string, orderField string, limit int, offset int, soryby int) []User {
-
-	if soryby != 0 {
-		if orderField == "name" {
-			result := UserNameSort(usr.List)
-			if soryby == 1 {
-				sort.Sort(sort.Reverse(result))
-			} else {
-				sort.Sort(result)
-			}
-		} else if orderField == "age" {
-			result := UserAgeSort(usr.List)
-			if soryby == 1 {
-				sort.Sort(sort.Reverse(result))
-			} else {
-				sort.Sort(result)
-			}
-		} else if orderField == "id" {
-			result := UserIdSOrt(usr.List)
-			if soryby == 1 {
-				sort.Sort(sort.Reverse(result))
-			} else {
-				sort.Sort(result)
-			}
-		}
-	}
-
-	var userFilter []User
-
-	for _, userEnt := range usr.List {
-		if query != "" && !strings.Contains(userEnt.FirstName+" "+userEnt.LastName, query) && !strings.Contains(userEnt.About, query) {
-			continue
-		}
-
-		userFilter = append(userFilter, User{
-			Id:     userEnt.ID,
-			Name:   userEnt.FirstName + " " + userEnt.LastName,
-			Age:    userEnt.Age,
-			About:  userEnt.About,
-			Gender: userEnt.Gender,
-		})
-	}
-
-	var userList []User
-
-	k := 0
-	for i, userEnt := range userFilter {
-		if k == limit {
-			break
-		}
-
-		if i >= offset {
-			userList = append(userList, User{
-				Id:     userEnt.Id,
-				Name:   userEnt.Name,
-				Age:    userEnt.Age,
-				About:  userEnt.About,
-				Gender: userEnt.Gender,
-			})
-
-			k++
-		}
-	}
-
-	return userList
-}
-
-func loadUsers() (Users, error) {
-	xmlData, err := ioutil.ReadFile("./dataset.xml")
-	if err != nil {
-		panic(err)
-	}
-	v := new(Users)
-	err = xml.Unmarshal(xmlData, &v)
-	if err != nil {
-		return Users{[]UserXml{}}, err
-	}
-	return *v, nil
-}
-
-func contains(arr [3]string, str string) bool {
-	for _, a := range arr {
-		if a == str {
-			return true
-		}
-	}
-	return false
-}
-
-func handleRequest(r *http.Request) ([]User, error) {
-	users, err := loadUsers()
-
-	var userList []User
-
-	if err != nil {
-		return userList, err
-	}
-
-	fields := [3]string{}
-	fields[0] = "name"
-	fields[1] = "id"
-	fields[2] = "age"
-
-	var orderField string
-	if contains(fields, strings.ToLower(r.FormValue("order_field"))) {
-		orderField = strings.ToLower(r.FormValue("order_field"))
-	} else if r.FormValue("order_field") == "" {
-		orderField = "name"
-	} else {
-		return userList, errors.New("wrong_order_field_paramter")
-	}
-
-	limit := 10
-	if r.FormValue("limit") != "" {
-		limit, err = strconv.Atoi(r.FormValue("limit"))
-
-		if err != nil {
-			return userList, err
-		}
-	}
-
-	offset := 0
-	if r.FormValue("offset") != "" {
-		offset, err = strconv.Atoi(r.FormValue("offset"))
-
-		if err != nil {
-			return userList, err
-		}
-	}
-
-	sortby := 0
-	if r.FormValue("order_by") != "" {
-		sortby, err = strconv.Atoi(r.FormValue("order_by"))
-
-		if err != nil {
-			return userList, err
-		}
-	}
-
-	result := users.FindUsers(r.FormValue("query"), orderField, limit, offset, sortby)
-
-	return result, nil
-}
-
-/** эмулирует входящий запрос на сервер, который должен отдать соответствующий ответ*/
-func QueryDummy(w http.ResponseWriter, r *http.Request) {
-
-	StatusCode := 200
-	var result []User
-	var data []byte
-	var err error
-
-	if r.Header.Get("AccessToken") != "1234567890" {
-		StatusCode = http.StatusUnauthorized
-	} else {
-		result, err = handleRequest(r)
-
-		if err == nil {
-			data, _ = json.Marshal(result)
-		} else {
-			StatusCode = http.StatusBadRequest
-		}
-	}
-
-	//fmt.Println(result)
-	//fmt.Println(data)
-	switch StatusCode {
-	case http.StatusBadRequest:
-		if err.Error() == "wrong_order_field_paramter" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error":"ErrorBadOrderField"}`))
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, `{"status": 400, "err": "user_read_error"}`)
-		}
-	case http.StatusUnauthorized:
-		w.WriteHeader(http.StatusUnauthorized)
-		io.WriteString(w, `{"status": 401, "err": "bad_access_tocken"}`)
-	case 200:
-		w.WriteHeader(http.StatusOK)
-		w.Write(data)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-}
-
 func TestSearchServer(t *testing.T) {
 	cases := []TestCase{
 		tResultCase1(),
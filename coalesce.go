@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// flightGroup объединяет одновременные вызовы с одинаковым ключом в один. Общий вызов выполняется
+// со своим контекстом: отмена одного вызывающего его не прерывает, а отменяется он, только когда
+// ждать результата больше некому
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+	stats CoalesceStats
+}
+
+// CoalesceStats - счётчики объединения запросов
+type CoalesceStats struct {
+	// выполненные общие вызовы
+	Calls int
+	// вызовы, которые получили результат чужого вызова вместо своего
+	Shared int
+	// общие вызовы, отменённые потому, что все ожидавшие ушли
+	Abandoned int
+}
+
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	result  interface{}
+	err     error
+}
+
+// do выполняет fn или присоединяется к уже идущему вызову с тем же ключом. Результат общий для всех,
+// поэтому вызывающий не должен его менять
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flight{}
+	}
+	call, ok := g.calls[key]
+	if ok {
+		g.stats.Shared++
+	} else {
+		callCtx, cancel := context.WithCancel(context.Background())
+		call = &flight{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		g.stats.Calls++
+		go g.run(callCtx, key, call, fn)
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// новые вызовы с этим ключом не должны присоединяться к отменённому
+			g.forget(key, call)
+			g.stats.Abandoned++
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, call *flight, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			call.result, call.err = nil, fmt.Errorf("panic in coalesced call: %v", recovered)
+		}
+		g.mu.Lock()
+		g.forget(key, call)
+		g.mu.Unlock()
+		call.cancel()
+		close(call.done)
+	}()
+	call.result, call.err = fn(ctx)
+}
+
+// forget убирает вызов из группы, если его ещё не заменил новый; вызывается под g.mu
+func (g *flightGroup) forget(key string, call *flight) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+func (g *flightGroup) Stats() CoalesceStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+// Coalescer объединяет одновременные одинаковые вызовы SearchClient.FindUsers в один запрос к серверу.
+// Одинаковыми считаются вызовы с тем же нормализованным SearchRequest, адресом, версией API и токеном.
+// Нулевое значение готово к работе, один Coalescer можно делить между клиентами
+type Coalescer struct {
+	group flightGroup
+}
+
+// Stats возвращает счётчики объединения
+func (c *Coalescer) Stats() CoalesceStats {
+	return c.group.Stats()
+}
+
+func (c *Coalescer) do(ctx context.Context, key string, fn func(ctx context.Context) (*SearchResponse, error)) (*SearchResponse, error) {
+	result, err := c.group.do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return fn(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// каждый получает свою копию, как и из кэша
+	return copyResponse(result.(*SearchResponse)), nil
+}
+
+// searchFlights объединяет одинаковые одновременные поиски на сервере
+var searchFlights flightGroup
+
+// coalescedStore - хранилище запроса, у которого одинаковые одновременные FindRows и CountUsers
+// к одному датасету выполняются один раз. Ожидание прекращается с отменой запроса клиентом
+type coalescedStore struct {
+	store UserStore
+	// датасет, к которому относятся ключи: разные датасеты не должны делить результаты
+	scope string
+	ctx   context.Context
+}
+
+var _ UserStore = (*coalescedStore)(nil)
+var _ rowIterator = (*coalescedStore)(nil)
+
+// coalesce оборачивает хранилище запроса r; scope определяет датасет
+func coalesce(r *http.Request, store UserStore, scope string) UserStore {
+	if versioned, ok := store.(versionedStore); ok {
+		// файл мог поменяться между запросами: объединяем только поиски по одной версии
+		scope += "@" + versioned.Version().Tag
+	}
+	return &coalescedStore{store: store, scope: scope, ctx: r.Context()}
+}
+
+func (s *coalescedStore) FindRows(req SearchRequest) ([]UserXml, error) {
+	key := fmt.Sprintf("%s|rows|%q|%q|%d|%d|%d", s.scope, req.Query, req.OrderField, req.OrderBy, req.Limit, req.Offset)
+	result, err := searchFlights.do(s.ctx, key, func(context.Context) (interface{}, error) {
+		return s.store.FindRows(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	spanFromContext(s.ctx).SetAttribute("search.result_count", len(result.([]UserXml)))
+	return result.([]UserXml), nil
+}
+
+// EachRow не объединяется: каждая выгрузка читает записи сама, не держа их в памяти
+func (s *coalescedStore) EachRow(ctx context.Context, req SearchRequest, fn func(UserXml) error) error {
+	return eachRow(ctx, s.store, req, fn)
+}
+
+func (s *coalescedStore) CountUsers(query string) (int, error) {
+	key := fmt.Sprintf("%s|count|%q", s.scope, query)
+	result, err := searchFlights.do(s.ctx, key, func(context.Context) (interface{}, error) {
+		return s.store.CountUsers(query)
+	})
+	if err != nil {
+		return 0, err
+	}
+	spanFromContext(s.ctx).SetAttribute("search.total", result.(int))
+	return result.(int), nil
+}
+
+func (s *coalescedStore) Version() DatasetVersion {
+	if versioned, ok := s.store.(versionedStore); ok {
+		return versioned.Version()
+	}
+	return DatasetVersion{}
+}
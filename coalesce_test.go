@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor ждёт, пока условие не станет верным, например пока все вызовы не присоединятся к общему
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlightGroupSharesCall(t *testing.T) {
+	group := &flightGroup{}
+	gate := make(chan struct{})
+	calls := 0
+	fn := func(context.Context) (interface{}, error) {
+		calls++
+		<-gate
+		return 42, nil
+	}
+
+	results := make(chan interface{}, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			result, _ := group.do(context.Background(), "key", fn)
+			results <- result
+		}()
+	}
+	waitFor(t, "callers", func() bool { return group.Stats().Shared == 4 })
+	close(gate)
+	for i := 0; i < 5; i++ {
+		if result := <-results; result != 42 {
+			t.Errorf("unexpected result %v", result)
+		}
+	}
+	if calls != 1 || group.Stats().Calls != 1 {
+		t.Errorf("expected one call, got %d", calls)
+	}
+
+	// завершённый вызов не запоминается
+	if result, err := group.do(context.Background(), "key", func(context.Context) (interface{}, error) { return 0, errTest }); result != 0 || err != errTest {
+		t.Errorf("expected new call after the first finished, got %v %v", result, err)
+	}
+}
+
+func TestFlightGroupCancellation(t *testing.T) {
+	group := &flightGroup{}
+	gate := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-gate
+		return "done", ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := group.do(ctx, "key", fn)
+		canceled <- err
+	}()
+	waitFor(t, "first call", func() bool { return group.Stats().Calls == 1 })
+	finished := make(chan interface{}, 1)
+	go func() {
+		result, _ := group.do(context.Background(), "key", fn)
+		finished <- result
+	}()
+	waitFor(t, "second caller", func() bool { return group.Stats().Shared == 1 })
+
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	close(gate)
+	if result := <-finished; result != "done" {
+		t.Errorf("cancellation of one caller must not abort the shared call, got %v", result)
+	}
+
+	// когда уходят все, общий вызов отменяется, а следующий начинается заново
+	gate = make(chan struct{})
+	ctx, cancel = context.WithCancel(context.Background())
+	go group.do(ctx, "abandoned", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(gate)
+		return nil, ctx.Err()
+	})
+	waitFor(t, "abandoned call", func() bool { return group.Stats().Calls == 2 })
+	cancel()
+	<-gate
+	if group.Stats().Abandoned != 1 {
+		t.Errorf("expected abandoned call, got %+v", group.Stats())
+	}
+	if result, err := group.do(context.Background(), "abandoned", func(context.Context) (interface{}, error) { return "fresh", nil }); result != "fresh" || err != nil {
+		t.Errorf("expected a fresh call after abandonment, got %v %v", result, err)
+	}
+}
+
+// gatedHandler - SearchServer, который отвечает только после release и считает запросы
+type gatedHandler struct {
+	mu       sync.Mutex
+	requests int
+	gate     chan struct{}
+	canceled chan struct{}
+}
+
+func newGatedHandler() *gatedHandler {
+	return &gatedHandler{gate: make(chan struct{}), canceled: make(chan struct{}, 10)}
+}
+
+func (h *gatedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.requests++
+	h.mu.Unlock()
+	select {
+	case <-h.gate:
+		SearchServer(w, r)
+	case <-r.Context().Done():
+		h.canceled <- struct{}{}
+	}
+}
+
+func (h *gatedHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests
+}
+
+func TestSearchClientCoalesce(t *testing.T) {
+	handler := newGatedHandler()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	client := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Coalesce: &Coalescer{}}
+	req := SearchRequest{Limit: 5, Query: "Boyd"}
+
+	type outcome struct {
+		response *SearchResponse
+		err      error
+	}
+	outcomes := make(chan outcome, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			response, err := client.FindUsers(req)
+			outcomes <- outcome{response, err}
+		}()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := client.FindUsersContext(ctx, req)
+		canceled <- err
+	}()
+	waitFor(t, "callers", func() bool { return client.Coalesce.Stats().Shared == 5 })
+
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	close(handler.gate)
+	var first *SearchResponse
+	for i := 0; i < 5; i++ {
+		result := <-outcomes
+		if result.err != nil || len(result.response.Users) != 1 {
+			t.Fatalf("unexpected result %v %v", result.response, result.err)
+		}
+		if first == nil {
+			first = result.response
+			first.Users[0].Name = "changed"
+		} else if result.response.Users[0].Name == "changed" {
+			t.Errorf("callers must get their own copies")
+		}
+	}
+	if handler.count() != 1 {
+		t.Errorf("expected 1 request, got %d", handler.count())
+	}
+
+	// разные запросы не объединяются
+	client.FindUsers(SearchRequest{Limit: 5})
+	client.FindUsers(SearchRequest{Limit: 6})
+	if handler.count() != 3 {
+		t.Errorf("expected 3 requests, got %d", handler.count())
+	}
+}
+
+func TestSearchClientCoalesceAbandoned(t *testing.T) {
+	handler := newGatedHandler()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	defer close(handler.gate)
+
+	client := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Coalesce: &Coalescer{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	// ждать больше некому, поэтому запрос к серверу отменён
+	select {
+	case <-handler.canceled:
+	case <-time.After(time.Second):
+		t.Errorf("expected the abandoned request to be canceled")
+	}
+}
+
+// gatedStore - хранилище, поиск в котором ждёт gate
+type gatedStore struct {
+	UserStore
+	mu    sync.Mutex
+	finds int
+	gate  chan struct{}
+}
+
+func (s *gatedStore) FindRows(req SearchRequest) ([]UserXml, error) {
+	s.mu.Lock()
+	s.finds++
+	s.mu.Unlock()
+	<-s.gate
+	return s.UserStore.FindRows(req)
+}
+
+func TestSearchServerCoalesce(t *testing.T) {
+	server, _ := newTestTenants(t)
+	store := &gatedStore{UserStore: server.tenants["acme"].store, gate: make(chan struct{})}
+	server.tenants["acme"].store = store
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	before := searchFlights.Stats()
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			client := &SearchClient{AccessToken: acmeToken, URL: ts.URL, Tenant: "acme", Version: APIVersion2}
+			_, err := client.FindUsers(SearchRequest{Limit: 5, OrderField: "age"})
+			errs <- err
+		}()
+	}
+	waitFor(t, "server callers", func() bool { return searchFlights.Stats().Shared-before.Shared >= 3 })
+	close(store.gate)
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	if store.finds != 1 {
+		t.Errorf("expected one search, got %d", store.finds)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+
+	// ответы меньше этого размера отдаются без сжатия: заголовки сжатия съедят выигрыш
+	defaultCompressMinSize = 1024
+)
+
+// contentEncoding - алгоритм сжатия тела для Content-Encoding
+type contentEncoding struct {
+	name      string
+	newWriter func(w io.Writer) (compressWriter, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// compressWriter - сжимающий writer, который умеет отдать накопленное без закрытия потока
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// contentEncodings - подключённые алгоритмы в порядке предпочтения; zstd добавляет сборка с тегом zstd
+var contentEncodings = []contentEncoding{{
+	name: EncodingGzip,
+	newWriter: func(w io.Writer) (compressWriter, error) {
+		return gzip.NewWriter(w), nil
+	},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+}}
+
+func findEncoding(name string) (contentEncoding, bool) {
+	for _, encoding := range contentEncodings {
+		if encoding.name == name {
+			return encoding, true
+		}
+	}
+	return contentEncoding{}, false
+}
+
+// acceptEncoding - значение Accept-Encoding клиента: все подключённые алгоритмы в порядке предпочтения
+func acceptEncoding() string {
+	names := make([]string, 0, len(contentEncodings))
+	for _, encoding := range contentEncodings {
+		names = append(names, encoding.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// negotiateEncoding выбирает алгоритм по Accept-Encoding запроса; при равных q побеждает указанный раньше
+func negotiateEncoding(r *http.Request) (contentEncoding, bool) {
+	supported := map[string]string{}
+	for _, encoding := range contentEncodings {
+		supported[encoding.name] = encoding.name
+	}
+	return findEncoding(negotiateAccept(r.Header.Get("Accept-Encoding"), supported, ""))
+}
+
+// CompressHandler сжимает ответы next алгоритмом, который принимает клиент (zstd, если подключён, иначе gzip).
+// Ответы короче minSize байт отдаются как есть, 0 - defaultCompressMinSize. Потоковые ответы,
+// которые сбрасывают буфер раньше, чем наберут minSize, сжимаются всегда, с отдачей данных на каждом Flush
+func CompressHandler(next http.Handler, minSize int) http.Handler {
+	if minSize <= 0 {
+		minSize = defaultCompressMinSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding, ok := negotiateEncoding(r)
+		if !ok || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		response := &compressResponse{ResponseWriter: w, encoding: encoding, minSize: minSize, status: http.StatusOK}
+		defer response.close()
+		next.ServeHTTP(response, r)
+	})
+}
+
+// compressResponse копит начало ответа, пока не станет ясно, стоит ли его сжимать
+type compressResponse struct {
+	http.ResponseWriter
+	encoding contentEncoding
+	minSize  int
+
+	status      int
+	wroteHeader bool
+	buffer      bytes.Buffer
+	// решение принято: дальше пишем в writer или, если он nil, напрямую
+	started bool
+	writer  compressWriter
+	err     error
+}
+
+func (c *compressResponse) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.status, c.wroteHeader = status, true
+	// у этих ответов нет тела, а уже сжатое повторно не сжимаем
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		c.Header().Get("Content-Encoding") != "" {
+		c.start(false)
+	}
+}
+
+func (c *compressResponse) Write(data []byte) (int, error) {
+	c.wroteHeader = true
+	if !c.started {
+		c.buffer.Write(data)
+		if c.buffer.Len() >= c.minSize {
+			c.start(true)
+		}
+		return len(data), c.err
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.writer != nil {
+		return c.writer.Write(data)
+	}
+	return c.ResponseWriter.Write(data)
+}
+
+func (c *compressResponse) Flush() {
+	if !c.started {
+		c.start(true)
+	}
+	if c.writer != nil && c.err == nil {
+		c.err = c.writer.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// start отправляет заголовки и накопленное начало ответа, сжатое или нет
+func (c *compressResponse) start(compress bool) {
+	c.started = true
+	header := c.Header()
+	if compress && header.Get("Content-Encoding") == "" {
+		c.writer, c.err = c.encoding.newWriter(c.ResponseWriter)
+		if c.err != nil {
+			return
+		}
+		header.Set("Content-Encoding", c.encoding.name)
+		header.Del("Content-Length")
+		// сжатое тело отличается побайтно, поэтому ETag становится слабым, а перепроверка по нему продолжает работать
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.buffer.Len() == 0 {
+		return
+	}
+	if c.writer != nil {
+		_, c.err = c.writer.Write(c.buffer.Bytes())
+	} else {
+		_, c.err = c.ResponseWriter.Write(c.buffer.Bytes())
+	}
+	c.buffer.Reset()
+}
+
+// close дописывает ответ: короткий отдаёт без сжатия, у сжатого закрывает поток
+func (c *compressResponse) close() {
+	if !c.started {
+		if !c.wroteHeader {
+			return
+		}
+		c.start(false)
+	}
+	if c.writer != nil {
+		c.writer.Close()
+	}
+}
+
+// decodedBody оборачивает тело ответа распаковкой по Content-Encoding
+func decodedBody(resp *http.Response) (io.ReadCloser, error) {
+	name := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if name == "" || name == "identity" || resp.StatusCode == http.StatusNotModified {
+		return resp.Body, nil
+	}
+	encoding, ok := findEncoding(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encoding %s", name)
+	}
+	reader, err := encoding.newReader(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cant decode %s response: %s", name, err)
+	}
+	return reader, nil
+}
+
+// readBody читает тело ответа целиком с распаковкой. Оборванное или испорченное сжатое тело - ошибка,
+// а не обрывок данных: у сжатого потока есть контрольная сумма и признак конца
+func readBody(resp *http.Response) ([]byte, error) {
+	body, err := decodedBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+			return nil, fmt.Errorf("cant decode %s response: %s", encoding, err)
+		}
+		return nil, fmt.Errorf("cant read response: %s", err)
+	}
+	return data, nil
+}
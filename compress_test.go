@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compressedGet(t *testing.T, handler http.Handler, target, acceptEncoding string) (*httptest.ResponseRecorder, []byte) {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("AccessToken", serverAccessToken)
+	req.Header.Set("Accept", MimeSearchV2)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	body, err := readBody(recorder.Result())
+	if err != nil {
+		t.Fatalf("%s: cant read body: %s", target, err)
+	}
+	return recorder, body
+}
+
+func TestCompressHandler(t *testing.T) {
+	handler := CompressHandler(http.HandlerFunc(SearchServer), 0)
+	_, plain := compressedGet(t, http.HandlerFunc(SearchServer), "/?limit=25", "")
+
+	compressed, body := compressedGet(t, handler, "/?limit=25", "br, gzip;q=0.8")
+	if encoding := compressed.Header().Get("Content-Encoding"); encoding != EncodingGzip {
+		t.Fatalf("expected gzip, got %q", encoding)
+	}
+	if !bytes.Equal(body, plain) || compressed.Body.Len() >= len(plain) {
+		t.Errorf("unexpected compressed body: %d bytes for %d", compressed.Body.Len(), len(plain))
+	}
+	if etag := compressed.Header().Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("expected weak ETag for compressed body, got %q", etag)
+	}
+	if vary := strings.Join(compressed.Header()["Vary"], ", "); !strings.Contains(vary, "Accept-Encoding") || !strings.Contains(vary, "AccessToken") {
+		t.Errorf("unexpected Vary %q", vary)
+	}
+
+	for name, test := range map[string]struct {
+		handler        http.Handler
+		acceptEncoding string
+	}{
+		"not accepted":   {handler, ""},
+		"unknown":        {handler, "br"},
+		"refused":        {handler, "gzip;q=0"},
+		"below minimum":  {CompressHandler(http.HandlerFunc(SearchServer), 1<<20), "gzip"},
+		"error response": {handler, "gzip"},
+	} {
+		target := "/?limit=25"
+		if name == "error response" {
+			target = "/?order_field=bad"
+		}
+		recorder, _ := compressedGet(t, test.handler, target, test.acceptEncoding)
+		if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" {
+			t.Errorf("%s: expected plain response, got %q", name, encoding)
+		}
+	}
+
+	// 304 не сжимается, а слабый ETag сжатого ответа по-прежнему подходит для перепроверки
+	req := httptest.NewRequest("GET", "/?limit=25", nil)
+	req.Header.Set("AccessToken", serverAccessToken)
+	req.Header.Set("Accept", MimeSearchV2)
+	req.Header.Set("Accept-Encoding", EncodingGzip)
+	req.Header.Set("If-None-Match", compressed.Header().Get("ETag"))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 || recorder.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected plain 304, got %d %q", recorder.Code, recorder.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestSearchClientCompression(t *testing.T) {
+	ts := httptest.NewServer(CompressHandler(http.HandlerFunc(SearchServer), 0))
+	defer ts.Close()
+	var encodings []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err == nil {
+			encodings = append(encodings, resp.Header.Get("Content-Encoding"))
+		}
+		return resp, err
+	})
+
+	req := SearchRequest{Limit: 25, OrderField: "age", OrderBy: OrderByDesc}
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		encodings = nil
+		compressed := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Version: version, Transport: transport}
+		plain := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Version: version, Transport: transport, DisableCompression: true}
+		expected, err := plain.FindUsers(req)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %s", version, err)
+		}
+		result, err := compressed.FindUsers(req)
+		if err != nil || len(result.Users) != len(expected.Users) || result.Users[24] != expected.Users[24] {
+			t.Errorf("[%s] unexpected result %v %v", version, result, err)
+		}
+		if len(encodings) != 2 || encodings[0] != "" || encodings[1] != contentEncodings[0].name {
+			t.Errorf("[%s] expected plain and %s responses, got %v", version, contentEncodings[0].name, encodings)
+		}
+	}
+
+	count := 0
+	exporter := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+	if err := exporter.StreamUsers(SearchRequest{}, func(User) error { count++; return nil }); err != nil || count != 35 {
+		t.Errorf("expected 35 exported users, got %d %v", count, err)
+	}
+
+	cached := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Cache: NewResponseCache(0, 10)}
+	cached.FindUsers(req)
+	if _, err := cached.FindUsers(req); err != nil || cached.Cache.Stats().Revalidated != 1 {
+		t.Errorf("expected revalidation through compression, got %+v %v", cached.Cache.Stats(), err)
+	}
+}
+
+// gzipped сжимает data так же, как CompressHandler
+func gzipped(t *testing.T, data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	writer.Write(data)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("cant compress: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestSearchClientBrokenCompressedBody(t *testing.T) {
+	req := httptest.NewRequest("GET", "/?limit=6", nil)
+	req.Header.Set("AccessToken", serverAccessToken)
+	recorder := httptest.NewRecorder()
+	SearchServer(recorder, req)
+	body := gzipped(t, recorder.Body.Bytes())
+
+	corrupt := append([]byte(nil), body...)
+	corrupt[len(corrupt)-6] ^= 0xff
+
+	for name, test := range map[string]struct {
+		encoding string
+		body     []byte
+	}{
+		"truncated":      {EncodingGzip, body[:len(body)/2]},
+		"no trailer":     {EncodingGzip, body[:len(body)-4]},
+		"corrupt":        {EncodingGzip, corrupt},
+		"not compressed": {EncodingGzip, recorder.Body.Bytes()},
+		"empty":          {EncodingGzip, nil},
+		"unknown":        {"br", body},
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", test.encoding)
+			w.Write(test.body)
+		}))
+		client := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Version: APIVersion1}
+		result, err := client.FindUsers(SearchRequest{Limit: 5})
+		if err == nil || !strings.Contains(err.Error(), test.encoding) {
+			t.Errorf("%s: expected decode error, got %v %v", name, result, err)
+		}
+		count := 0
+		if err := client.StreamUsers(SearchRequest{}, func(User) error { count++; return nil }); err == nil {
+			t.Errorf("%s: expected export error", name)
+		}
+		ts.Close()
+	}
+}
+
+func TestCassetteRecordsDecompressedBody(t *testing.T) {
+	ts := httptest.NewServer(CompressHandler(http.HandlerFunc(SearchServer), 0))
+	defer ts.Close()
+	path := filepath.Join(t.TempDir(), "compressed.jsonl")
+
+	recorder := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Transport: &CassetteTransport{Path: path, Mode: ModeRecord}}
+	expected, err := recorder.FindUsers(SearchRequest{Limit: 25})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if bytes.Contains(data, []byte("Content-Encoding")) {
+		t.Errorf("cassette must store decompressed body")
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatalf("cant load cassette: %s", err)
+	}
+	replayed, err := (&SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Transport: replayer}).FindUsers(SearchRequest{Limit: 25})
+	if err != nil || replayed.Users[24] != expected.Users[24] {
+		t.Errorf("unexpected replayed result %v %v", replayed, err)
+	}
+}
+
+func TestCompressHandlerStreamsExport(t *testing.T) {
+	ts := httptest.NewServer(CompressHandler(http.HandlerFunc(SearchServer), 0))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+exportPath+"?query=Boyd", nil)
+	req.Header.Set("AccessToken", serverAccessToken)
+	req.Header.Set("Accept-Encoding", EncodingGzip)
+	resp, err := (&http.Client{Timeout: time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	// выгрузка короче порога, но сбрасывает буфер после каждой строки, поэтому сжимается потоком
+	if resp.Header.Get("Content-Encoding") != EncodingGzip {
+		t.Errorf("expected compressed export, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	body, err := readBody(resp)
+	if err != nil || !bytes.Contains(body, []byte(`"done":true`)) {
+		t.Errorf("unexpected export body %q %v", body, err)
+	}
+}
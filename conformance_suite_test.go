@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ConformanceSuite проверяет, что http.Handler соблюдает контракт SearchServer:
+// сортировку по каждому order_field в обе стороны и OrderByAsIs, границы limit/offset и NextPage,
+// поиск по query, ErrorBadOrderField и 401 на неверный токен.
+// Ожидаемые ответы считаются по фикстуре Dataset, Handler должен работать на тех же данных
+type ConformanceSuite struct {
+	Handler http.Handler
+	// путь к фикстуре в формате dataset.xml
+	Dataset string
+	// токен, который Handler принимает
+	AccessToken string
+	// версия API, которой пользуется клиент; пусто - договориться с сервером
+	Version string
+}
+
+// Run запускает все проверки как подтесты t
+func (s ConformanceSuite) Run(t *testing.T) {
+	users, err := loadUsersFrom(s.Dataset)
+	if err != nil {
+		t.Fatalf("cant load fixture %s: %s", s.Dataset, err)
+	}
+	if len(users.List) == 0 {
+		t.Fatalf("fixture %s has no rows", s.Dataset)
+	}
+	reference := &FakeSearcher{}
+	for _, row := range users.List {
+		reference.Users = append(reference.Users, row.User())
+	}
+
+	ts := httptest.NewServer(s.Handler)
+	defer ts.Close()
+	searcher := &SearchClient{AccessToken: s.AccessToken, URL: ts.URL, Version: s.Version}
+
+	t.Run("order", func(t *testing.T) {
+		for _, field := range []string{"", "Id", "Age", "Name", "id", "age", "name"} {
+			for _, orderBy := range []int{OrderByAsc, OrderByAsIs, OrderByDesc} {
+				for _, offset := range []int{0, len(reference.Users) / 2} {
+					checkConformance(t, searcher, reference, SearchRequest{Limit: 25, Offset: offset, OrderField: field, OrderBy: orderBy})
+				}
+			}
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		total := len(reference.Users)
+		for _, limit := range []int{0, 1, 24, 25, 26, 100, total - 1, total, total + 1} {
+			for _, offset := range []int{0, 1, total - limit, total - 1, total, total + 5} {
+				if limit < 0 || offset < 0 {
+					continue
+				}
+				checkConformance(t, searcher, reference, SearchRequest{Limit: limit, Offset: offset, OrderField: "id", OrderBy: OrderByAsc})
+			}
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		first, last := reference.Users[0], reference.Users[len(reference.Users)-1]
+		queries := []string{"", first.Name, strings.SplitN(first.Name, " ", 2)[0], "no user has this in name or about"}
+		if words := strings.Fields(last.About); len(words) > 1 {
+			queries = append(queries, words[0]+" "+words[1], strings.ToLower(words[0]))
+		}
+		for _, query := range queries {
+			checkConformance(t, searcher, reference, SearchRequest{Limit: 25, Query: query, OrderField: "id", OrderBy: OrderByAsc})
+		}
+	})
+
+	t.Run("bad order field", func(t *testing.T) {
+		for _, field := range []string{"picture", "About", "id desc"} {
+			_, err := searcher.FindUsers(SearchRequest{Limit: 1, OrderField: field})
+			if expected := fmt.Sprintf("OrderFeld %s invalid", field); err == nil || err.Error() != expected {
+				t.Errorf("order_field %q: expected error %q, got %v", field, expected, err)
+			}
+		}
+	})
+
+	t.Run("bad token", func(t *testing.T) {
+		bad := &SearchClient{AccessToken: s.AccessToken + "-bad", URL: ts.URL, Version: s.Version}
+		if _, err := bad.FindUsers(SearchRequest{Limit: 1}); err == nil || err.Error() != "Bad AccessToken" {
+			t.Errorf("expected Bad AccessToken error, got %v", err)
+		}
+
+		req, _ := http.NewRequest("GET", ts.URL+"?limit=1", nil)
+		req.Header.Set("AccessToken", s.AccessToken+"-bad")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+}
+
+// checkConformance сравнивает ответ с эталоном. При сортировке записи с равным ключом
+// могут идти в любом порядке, поэтому сравниваются ключи сортировки и то, что каждая запись есть в фикстуре
+func checkConformance(t *testing.T, searcher UserSearcher, reference *FakeSearcher, req SearchRequest) {
+	t.Helper()
+
+	expected, expectedErr := reference.FindUsers(req)
+	result, err := searcher.FindUsers(req)
+	if (err != nil) != (expectedErr != nil) {
+		t.Errorf("%+v: expected error %v, got %v", req, expectedErr, err)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if result.NextPage != expected.NextPage {
+		t.Errorf("%+v: expected NextPage %v, got %v", req, expected.NextPage, result.NextPage)
+	}
+	if len(result.Users) != len(expected.Users) {
+		t.Errorf("%+v: expected %d users, got %d", req, len(expected.Users), len(result.Users))
+		return
+	}
+
+	known := map[User]bool{}
+	for _, user := range reference.Users {
+		known[user] = true
+	}
+	orderField, _ := normalizeOrderField(req.OrderField)
+	for i, user := range result.Users {
+		if !known[user] {
+			t.Errorf("%+v: user %d is not in fixture: %#v", req, i, user)
+		}
+		if req.OrderBy == OrderByAsIs {
+			if user != expected.Users[i] {
+				t.Errorf("%+v: expected user %d to be %d, got %d", req, i, expected.Users[i].Id, user.Id)
+			}
+			continue
+		}
+		if key, expectedKey := sortKey(user, orderField), sortKey(expected.Users[i], orderField); key != expectedKey {
+			t.Errorf("%+v: expected %s of user %d to be %s, got %s", req, orderField, i, expectedKey, key)
+		}
+	}
+}
+
+func sortKey(user User, orderField string) string {
+	switch orderField {
+	case "id":
+		return fmt.Sprint(user.Id)
+	case "age":
+		return fmt.Sprint(user.Age)
+	}
+	return user.Name
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSearchServerConformance(t *testing.T) {
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		t.Run(version, func(t *testing.T) {
+			ConformanceSuite{
+				Handler:     http.HandlerFunc(SearchServer),
+				Dataset:     "./dataset.xml",
+				AccessToken: serverAccessToken,
+				Version:     version,
+			}.Run(t)
+		})
+	}
+}
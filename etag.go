@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheMaxAge - сколько клиенты могут отдавать ответ из кэша, не перепроверяя его; 0 - перепроверять
+// перед каждым использованием. Задаётся флагом serve -cache-max-age
+var cacheMaxAge time.Duration
+
+// responseETag строит ETag из версии датасета, вида ответа и нормализованных параметров поиска,
+// поэтому одинаковые запросы к одному датасету получают один ETag, как бы ни были записаны параметры
+func responseETag(version DatasetVersion, variant string, params searchParams) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%q|%q|%d|%d|%d", version.Tag, variant,
+		params.Query, params.OrderField, params.OrderBy, params.Limit, params.Offset)))
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// notModified ставит ETag, Last-Modified и Cache-Control для ответа из store и, если условный GET-запрос
+// совпал с текущей версией, отвечает 304 без тела. Возвращает true, если ответ уже записан.
+// Для хранилищ без версии ничего не делает
+func notModified(w http.ResponseWriter, r *http.Request, store UserStore, variant string, params searchParams) bool {
+	versioned, ok := store.(versionedStore)
+	if !ok {
+		return false
+	}
+	version := versioned.Version()
+	if version.Tag == "" {
+		return false
+	}
+
+	etag := responseETag(version, variant, params)
+	header := w.Header()
+	header.Set("ETag", etag)
+	if !version.Modified.IsZero() {
+		header.Set("Last-Modified", version.Modified.UTC().Format(http.TimeFormat))
+	}
+	// хранить можно, перепроверять - каждый раз или по истечении cacheMaxAge; ответ зависит от формата и токена
+	header.Set("Cache-Control", responseCacheControl())
+	header.Add("Vary", "Accept, AccessToken")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if !requestNotModified(r, etag, version.Modified) {
+		return false
+	}
+	header.Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func responseCacheControl() string {
+	if cacheMaxAge <= 0 {
+		return "no-cache"
+	}
+	return "max-age=" + strconv.Itoa(int(cacheMaxAge/time.Second))
+}
+
+// requestNotModified проверяет If-None-Match, а если его нет - If-Modified-Since
+func requestNotModified(r *http.Request, etag string, modified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// для If-None-Match используется слабое сравнение
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
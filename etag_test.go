@@ -0,0 +1,256 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func conditionalGet(t *testing.T, target, accept string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("AccessToken", serverAccessToken)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	SearchServer(recorder, req)
+	return recorder
+}
+
+// useCacheMaxAge задаёт max-age ответов SearchServer до конца теста
+func useCacheMaxAge(t *testing.T, maxAge time.Duration) {
+	previous := cacheMaxAge
+	cacheMaxAge = maxAge
+	t.Cleanup(func() { cacheMaxAge = previous })
+}
+
+func TestSearchServerETag(t *testing.T) {
+	useStore(t, StoreConfig{Kind: StoreJSON, Path: writeStore(t, StoreJSON)})
+
+	for _, accept := range []string{MimeSearchV1, MimeSearchV2, MimeCSV} {
+		first := conditionalGet(t, "/?limit=5&order_field=id&order_by=-1", accept, nil)
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" {
+			t.Fatalf("[%s] expected 200 with ETag, got %d %q", accept, first.Code, etag)
+		}
+		if first.Header().Get("Last-Modified") == "" || first.Header().Get("Cache-Control") != "no-cache" {
+			t.Errorf("[%s] unexpected cache headers %v", accept, first.Header())
+		}
+
+		// параметры нормализуются: порядок в строке запроса и пустой query не меняют ETag
+		for _, header := range []http.Header{
+			{"If-None-Match": {etag}},
+			{"If-None-Match": {`"other", W/` + etag}},
+			{"If-None-Match": {"*"}},
+			{"If-Modified-Since": {first.Header().Get("Last-Modified")}},
+		} {
+			second := conditionalGet(t, "/?order_by=-1&limit=5&order_field=id&query=", accept, header)
+			if second.Code != http.StatusNotModified || second.Body.Len() != 0 {
+				t.Errorf("[%s] %v: expected empty 304, got %d %q", accept, header, second.Code, second.Body.String())
+			}
+			if second.Header().Get("ETag") != etag {
+				t.Errorf("[%s] %v: 304 must repeat ETag", accept, header)
+			}
+		}
+
+		other := conditionalGet(t, "/?limit=5&order_field=id&order_by=1", accept, http.Header{"If-None-Match": {etag}})
+		if other.Code != http.StatusOK || other.Header().Get("ETag") == etag {
+			t.Errorf("[%s] expected another ETag for another query, got %d", accept, other.Code)
+		}
+	}
+
+	v1 := conditionalGet(t, "/?limit=5", MimeSearchV1, nil).Header().Get("ETag")
+	v2 := conditionalGet(t, "/?limit=5", MimeSearchV2, nil).Header().Get("ETag")
+	if v1 == v2 {
+		t.Errorf("v1 and v2 responses must have different ETags")
+	}
+
+	// POST не бывает условным, но ETag у ответа есть
+	post := httptest.NewRequest("POST", "/", nil)
+	post.Header.Set("AccessToken", serverAccessToken)
+	post.Header.Set("If-None-Match", "*")
+	recorder := httptest.NewRecorder()
+	SearchServer(recorder, post)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected 200 for POST, got %d", recorder.Code)
+	}
+}
+
+func TestSearchServerDefaultV1KeepsBaselineHeaders(t *testing.T) {
+	useStore(t, StoreConfig{Kind: StoreJSON, Path: writeStore(t, StoreJSON)})
+
+	// запрос без версии получает ответ исходного контракта: без типа версии, валидаторов и 304
+	legacy := conditionalGet(t, "/?limit=5", "", http.Header{"If-None-Match": {"*"}})
+	if legacy.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", legacy.Code)
+	}
+	for _, name := range []string{"ETag", "Last-Modified", "Cache-Control", "Vary"} {
+		if value := legacy.Header().Get(name); value != "" {
+			t.Errorf("unexpected %s: %q", name, value)
+		}
+	}
+	if ct := legacy.Header().Get("Content-Type"); ct == MimeSearchV1 {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+
+	for _, explicit := range []*httptest.ResponseRecorder{
+		conditionalGet(t, "/?limit=5", MimeSearchV1, nil),
+		conditionalGet(t, "/v1?limit=5", "", nil),
+	} {
+		if explicit.Header().Get("Content-Type") != MimeSearchV1 || explicit.Header().Get("ETag") == "" {
+			t.Errorf("explicit v1 must get its media type and ETag, got %v", explicit.Header())
+		}
+	}
+}
+
+func TestSearchServerETagChangesWithDataset(t *testing.T) {
+	path := writeStore(t, StoreJSON)
+	useStore(t, StoreConfig{Kind: StoreJSON, Path: path})
+
+	before := conditionalGet(t, "/?limit=5", MimeSearchV1, nil)
+	etag := before.Header().Get("ETag")
+
+	writeJSONDataset(t, path, []UserXml{{ID: 1, FirstName: "Only", LastName: "One"}})
+	modified := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatalf("cant touch dataset: %s", err)
+	}
+
+	after := conditionalGet(t, "/?limit=5", MimeSearchV1, http.Header{"If-None-Match": {etag}})
+	if after.Code != http.StatusOK || after.Header().Get("ETag") == etag {
+		t.Errorf("expected new ETag after dataset change, got %d", after.Code)
+	}
+	stale := conditionalGet(t, "/?limit=5", MimeSearchV1, http.Header{"If-Modified-Since": {before.Header().Get("Last-Modified")}})
+	if stale.Code != http.StatusOK {
+		t.Errorf("expected 200 for old If-Modified-Since, got %d", stale.Code)
+	}
+}
+
+func TestResponseCacheRevalidatesAgainstSearchServer(t *testing.T) {
+	server, smallPath := newTestTenants(t)
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		client, clock := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+		client.AccessToken, client.Tenant, client.Version = smallToken, "small", version
+		req := SearchRequest{Limit: 3, OrderField: "age", OrderBy: OrderByAsc}
+
+		first, err := client.FindUsers(req)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %s", version, err)
+		}
+		clock.now = clock.now.Add(2 * time.Minute)
+		second, err := client.FindUsers(req)
+		if err != nil || second.Users[2] != first.Users[2] || second.NextPage != first.NextPage {
+			t.Errorf("[%s] unexpected revalidated result %v %v", version, second, err)
+		}
+		if stats := client.Cache.Stats(); stats.Revalidated != 1 || stats.Misses != 1 {
+			t.Errorf("[%s] expected 304 from server, got %+v", version, stats)
+		}
+	}
+
+	// после перезагрузки датасета ETag меняется и клиент получает новые данные
+	client, clock := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+	client.AccessToken, client.Tenant = smallToken, "small"
+	client.FindUsers(SearchRequest{Limit: 25})
+	writeJSONDataset(t, smallPath, []UserXml{{ID: 1, FirstName: "Only", LastName: "One"}})
+	modified := time.Now().Add(time.Hour)
+	os.Chtimes(smallPath, modified, modified)
+	if err := server.Reload("small"); err != nil {
+		t.Fatalf("unexpected reload error: %s", err)
+	}
+	clock.now = clock.now.Add(2 * time.Minute)
+	result, err := client.FindUsers(SearchRequest{Limit: 25})
+	if err != nil || len(result.Users) != 1 {
+		t.Errorf("expected reloaded dataset, got %v %v", result, err)
+	}
+}
+
+func TestResponseCacheFreshFromSearchServer(t *testing.T) {
+	useCacheMaxAge(t, 5*time.Minute)
+	if header := conditionalGet(t, "/?limit=1", MimeSearchV1, nil).Header().Get("Cache-Control"); header != "max-age=300" {
+		t.Errorf("expected max-age=300, got %q", header)
+	}
+
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		server := &flakyServer{}
+		client, clock := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+		client.Version = version
+		req := SearchRequest{Limit: 3, OrderField: "age", OrderBy: OrderByAsc}
+
+		first, err := client.FindUsers(req)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %s", version, err)
+		}
+		// max-age сервера больше TTL: свежесть ограничивает TTL клиента
+		clock.now = clock.now.Add(30 * time.Second)
+		second, err := client.FindUsers(req)
+		if err != nil || second.Users[2] != first.Users[2] || server.set(false) != 1 {
+			t.Errorf("[%s] expected cached result without request, got %v %v", version, second, err)
+		}
+		clock.now = clock.now.Add(time.Minute)
+		if _, err := client.FindUsers(req); err != nil || server.set(false) != 2 {
+			t.Errorf("[%s] expected revalidation after TTL, got %v", version, err)
+		}
+		if stats := client.Cache.Stats(); stats.Hits != 1 || stats.Revalidated != 1 || stats.Misses != 1 {
+			t.Errorf("[%s] unexpected stats %+v", version, stats)
+		}
+	}
+
+	// max-age сервера меньше TTL: свежесть ограничивает сервер
+	useCacheMaxAge(t, 10*time.Second)
+	server := &flakyServer{}
+	client, clock := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+	client.FindUsers(SearchRequest{Limit: 3})
+	clock.now = clock.now.Add(20 * time.Second)
+	client.FindUsers(SearchRequest{Limit: 3})
+	if stats := client.Cache.Stats(); stats.Hits != 0 || stats.Revalidated != 1 || server.set(false) != 2 {
+		t.Errorf("expected revalidation after server max-age, got %+v", stats)
+	}
+}
+
+func TestSearchServerETagFollowsContent(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("change time of a file is only checked on linux")
+	}
+	path := filepath.Join(t.TempDir(), "dataset.json")
+	useStore(t, StoreConfig{Kind: StoreJSON, Path: path})
+	modified := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	write := func(id int) {
+		writeJSONDataset(t, path, []UserXml{{ID: id, FirstName: "Only", LastName: "One"}})
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatalf("cant touch dataset: %s", err)
+		}
+	}
+
+	write(1)
+	loaded := time.Now().Truncate(time.Second)
+	before := conditionalGet(t, "/?limit=5", MimeSearchV1, nil)
+	lastModified, err := http.ParseTime(before.Header().Get("Last-Modified"))
+	if err != nil || lastModified.Before(loaded) {
+		t.Errorf("Last-Modified must be the load time, not the file time, got %q", before.Header().Get("Last-Modified"))
+	}
+
+	// то же время изменения и тот же размер, другое содержимое
+	write(2)
+	after := conditionalGet(t, "/?limit=5", MimeSearchV1, http.Header{"If-None-Match": {before.Header().Get("ETag")}})
+	if after.Code != http.StatusOK || after.Header().Get("ETag") == before.Header().Get("ETag") {
+		t.Errorf("expected new ETag after a same-size rewrite, got %d", after.Code)
+	}
+	stale := conditionalGet(t, "/?limit=5", MimeSearchV1, http.Header{"If-Modified-Since": {before.Header().Get("Last-Modified")}})
+	if stale.Code != http.StatusOK {
+		t.Errorf("expected 200 for If-Modified-Since of the old version, got %d", stale.Code)
+	}
+
+	// перезапись тем же содержимым не меняет версию
+	write(2)
+	same := conditionalGet(t, "/?limit=5", MimeSearchV1, nil)
+	if same.Header().Get("ETag") != after.Header().Get("ETag") || same.Header().Get("Last-Modified") != after.Header().Get("Last-Modified") {
+		t.Errorf("unchanged content must keep its validators")
+	}
+}
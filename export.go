@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MimeNDJSON = "application/x-ndjson"
+
+	exportPath = "/export"
+)
+
+// у выгрузки нет общего таймаута, ограничено только ожидание заголовков ответа
+var streamClient = &http.Client{
+	Transport: &http.Transport{ResponseHeaderTimeout: time.Second},
+}
+
+// exportLine - строка NDJSON-выгрузки: пользователь либо завершающая строка с итогом или ошибкой.
+// Поток без завершающей строки считается оборванным
+type exportLine struct {
+	*User
+	Done  bool               `json:"done,omitempty"`
+	Count int                `json:"count,omitempty"`
+	Error *SearchErrorDetail `json:"error,omitempty"`
+}
+
+// ExportUsers отдаёт все подходящие записи построчно в NDJSON, сбрасывая буфер после каждой строки.
+// Учитываются query, order_field, order_by и offset, limit игнорируется
+func ExportUsers(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusUnauthorized, SearchErrorDetail{Code: ErrorCodeBadAccessToken, Message: "bad access token"})
+		return
+	}
+
+	store, err := requestStore(r)
+	if err != nil {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusInternalServerError, SearchErrorDetail{Code: ErrorCodeInternal, Message: "dataset is unavailable"})
+		return
+	}
+
+	params, err := parseSearchParams(r)
+	if err == nil && params.Offset < 0 {
+		err = &paramError{Field: "offset", Err: errNegative}
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusBadRequest, errorDetailV2(err))
+		return
+	}
+
+	req := params.request()
+	req.Limit = -1
+
+	w.Header().Set("Content-Type", MimeNDJSON)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	// записи читаются из хранилища по одной, и выгрузка прекращается, как только клиент ушёл
+	count := 0
+	err = eachRow(r.Context(), store, req, func(row UserXml) error {
+		user := row.User()
+		if err := encoder.Encode(exportLine{User: &user}); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		// заголовки уже отправлены: об ошибке сообщает завершающая строка
+		encoder.Encode(exportLine{Error: &SearchErrorDetail{Code: ErrorCodeInternal, Message: "dataset is unavailable"}})
+		return
+	}
+	encoder.Encode(exportLine{Done: true, Count: count})
+}
+
+// StreamUsers выгружает все подходящие записи NDJSON-потоком и передаёт их в fn по одной.
+// Limit не учитывается. Ошибка из fn прерывает выгрузку и возвращается как есть
+func (srv *SearchClient) StreamUsers(req SearchRequest, fn func(User) error) error {
+	return srv.StreamUsersContext(context.Background(), req, fn)
+}
+
+// StreamUsersContext - StreamUsers с контекстом: его отмена прерывает выгрузку между записями
+// и закрывает соединение, и сервер перестаёт читать датасет
+func (srv *SearchClient) StreamUsersContext(ctx context.Context, req SearchRequest, fn func(User) error) error {
+	if req.Offset < 0 {
+		return fmt.Errorf("offset must be > 0")
+	}
+
+	searcherParams := url.Values{}
+	searcherParams.Add("offset", strconv.Itoa(req.Offset))
+	searcherParams.Add("query", req.Query)
+	searcherParams.Add("order_field", req.OrderField)
+	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+
+	searcherReq, err := newRequest(strings.TrimSuffix(srv.target(), "/")+exportPath, req, searcherParams)
+	if err != nil {
+		return fmt.Errorf("cant build request: %s", err)
+	}
+	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	searcherReq.Header.Set("Accept", MimeNDJSON)
+	srv.acceptEncoding(searcherReq)
+
+	httpClient := streamClient
+	if srv.Transport != nil || srv.Metrics != nil {
+		httpClient = &http.Client{Transport: srv.roundTripper()}
+	}
+	resp, err := srv.send(httpClient, searcherReq.WithContext(ctx), nil)
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			srv.metrics().Timeout()
+			return fmt.Errorf("timeout for %s", searcherParams.Encode())
+		}
+		return fmt.Errorf("unknown error %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := readBody(resp)
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == MimeSearchV2 {
+			_, err := decodeV2(resp.StatusCode, body, req)
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("Bad AccessToken")
+		}
+		return fmt.Errorf("SearchServer fatal error")
+	}
+
+	body, err := decodedBody(resp)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	decoder := json.NewDecoder(body)
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := exportLine{}
+		err := decoder.Decode(&line)
+		if err == io.EOF {
+			return fmt.Errorf("export stream truncated after %d users", count)
+		}
+		if err != nil {
+			return fmt.Errorf("cant unpack export line: %s", err)
+		}
+
+		switch {
+		case line.Error != nil:
+			return fmt.Errorf("export failed after %d users: %s: %s", count, line.Error.Code, line.Error.Message)
+		case line.Done:
+			if line.Count != count {
+				return fmt.Errorf("export stream lost users: expected %d, got %d", line.Count, count)
+			}
+			return nil
+		case line.User != nil:
+			if err := fn(*line.User); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+}
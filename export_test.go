@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamUsers(t *testing.T) {
+	cases := []struct {
+		Token   string
+		Query   SearchRequest
+		Ids     []int
+		IsError bool
+	}{
+		{serverAccessToken, SearchRequest{OrderField: "Id", OrderBy: OrderByAsc, Offset: 31}, []int{31, 32, 33, 34}, false},
+		{serverAccessToken, SearchRequest{OrderField: "Id", OrderBy: OrderByDesc, Offset: 32, Limit: 1}, []int{2, 1, 0}, false},
+		{serverAccessToken, SearchRequest{Query: "Aguilar"}, []int{2}, false},
+		{serverAccessToken, SearchRequest{Query: "nobody"}, []int{}, false},
+		{serverAccessToken, SearchRequest{OrderField: "picture"}, []int{}, true},
+		{serverAccessToken, SearchRequest{Offset: -1}, []int{}, true},
+		{"bad", SearchRequest{}, []int{}, true},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+
+	for caseNum, item := range cases {
+		s := &SearchClient{AccessToken: item.Token, URL: ts.URL + "/v2/"}
+		ids := []int{}
+		err := s.StreamUsers(item.Query, func(user User) error {
+			ids = append(ids, user.Id)
+			return nil
+		})
+
+		if err != nil && !item.IsError {
+			t.Errorf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%d] expected error, got nil", caseNum)
+		}
+		if !reflect.DeepEqual(item.Ids, ids) {
+			t.Errorf("[%d] wrong users, expected %v, got %v", caseNum, item.Ids, ids)
+		}
+	}
+
+	count := 0
+	s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+	if err := s.StreamUsers(SearchRequest{}, func(User) error { count++; return nil }); err != nil || count != 35 {
+		t.Errorf("expected full export of 35 users, got %d, %#v", count, err)
+	}
+
+	stopErr := errors.New("stop")
+	if err := s.StreamUsers(SearchRequest{}, func(User) error { return stopErr }); err != stopErr {
+		t.Errorf("expected callback error, got %#v", err)
+	}
+	ts.Close()
+}
+
+func TestStreamUsersBrokenStream(t *testing.T) {
+	cases := []struct {
+		Status int
+		Body   string
+		Count  int
+	}{
+		{http.StatusOK, `{"Id":1}` + "\n", 1},
+		{http.StatusOK, `{"Id":1}` + "\n" + `{"error":{"code":"ErrorInternal","message":"disk"}}` + "\n", 1},
+		{http.StatusOK, `{"Id":1}` + "\n" + `{"done":true,"count":2}` + "\n", 1},
+		{http.StatusOK, `{"Id":1}` + "\n" + `{"Id":`, 1},
+		{http.StatusInternalServerError, ``, 0},
+		{http.StatusUnauthorized, ``, 0},
+	}
+
+	for caseNum, item := range cases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(item.Status)
+			io.WriteString(w, item.Body)
+		}))
+
+		s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+		count := 0
+		err := s.StreamUsers(SearchRequest{}, func(User) error { count++; return nil })
+		if err == nil {
+			t.Errorf("[%d] expected error, got nil", caseNum)
+		}
+		if count != item.Count {
+			t.Errorf("[%d] wrong count, expected %d, got %d", caseNum, item.Count, count)
+		}
+		ts.Close()
+	}
+}
+
+func TestExportUsersFlushes(t *testing.T) {
+	r := httptest.NewRequest("GET", "/export?query=Aguilar", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	w := httptest.NewRecorder()
+	ExportUsers(w, r)
+
+	if !w.Flushed {
+		t.Errorf("expected export to flush rows")
+	}
+	if ct := w.Header().Get("Content-Type"); ct != MimeNDJSON {
+		t.Errorf("wrong content type %s", ct)
+	}
+	expected := `{"Id":2,"Name":"Brooks Aguilar","Age":25,"About":"Velit ullamco est aliqua voluptate nisi do. Voluptate magna anim qui cillum aliqua sint veniam reprehenderit consectetur enim. Laborum dolore ut eiusmod ipsum ad anim est do tempor culpa ad do tempor. Nulla id aliqua dolore dolore adipisicing.\n","Gender":"male"}` + "\n" +
+		`{"done":true,"count":1}` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("wrong body, expected %s, got %s", expected, w.Body.String())
+	}
+}
+
+// cancelingWriter отменяет запрос после limit записей
+type cancelingWriter struct {
+	*httptest.ResponseRecorder
+	cancel func()
+	limit  int
+	writes int
+}
+
+func (w *cancelingWriter) Write(data []byte) (int, error) {
+	w.writes++
+	if w.writes == w.limit {
+		w.cancel()
+	}
+	return w.ResponseRecorder.Write(data)
+}
+
+func TestExportUsersStopsWhenClientLeaves(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := httptest.NewRequest("GET", "/export", nil).WithContext(ctx)
+	r.Header.Set("AccessToken", serverAccessToken)
+	w := &cancelingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel, limit: 3}
+	ExportUsers(w, r)
+
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 3 || strings.Contains(w.Body.String(), `"done"`) {
+		t.Errorf("expected export to stop after 3 rows, got %d lines: %s", lines, w.Body.String())
+	}
+}
+
+func TestStreamUsersContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+	s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err := s.StreamUsersContext(ctx, SearchRequest{}, func(User) error {
+		count++
+		if count == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || count != 2 {
+		t.Errorf("expected canceled export after 2 users, got %d, %v", count, err)
+	}
+
+	if err := s.StreamUsersContext(ctx, SearchRequest{}, func(User) error { return nil }); err != context.Canceled {
+		t.Errorf("expected canceled export before the request, got %v", err)
+	}
+}
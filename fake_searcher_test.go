@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeFailure - сбой, который FakeSearcher может изобразить вместо ответа
+type FakeFailure int
+
+const (
+	FakeOK FakeFailure = iota
+	FakeTimeout
+	FakeUnauthorized
+	FakeInternalError
+	FakeBadJSON
+)
+
+// FakeSearcher - UserSearcher в памяти для тестов кода, который зависит от поиска.
+// Фильтрует, сортирует и листает Users так же, как SearchServer, и возвращает те же ошибки, что SearchClient.
+// Сбои задаются через FailNext (по одному на вызов) или функцией Fail для каждого запроса
+type FakeSearcher struct {
+	Users []User
+	// если задана и вернула не FakeOK, вызов завершается этим сбоем
+	Fail func(req SearchRequest) FakeFailure
+
+	mu       sync.Mutex
+	failures []FakeFailure
+	requests []SearchRequest
+}
+
+var _ UserSearcher = (*FakeSearcher)(nil)
+
+// FailNext ставит сбои в очередь: каждый следующий вызов забирает из неё по одному
+func (f *FakeSearcher) FailNext(failures ...FakeFailure) {
+	f.mu.Lock()
+	f.failures = append(f.failures, failures...)
+	f.mu.Unlock()
+}
+
+// Requests возвращает запросы, пришедшие в FindUsers и StreamUsers, в порядке вызова
+func (f *FakeSearcher) Requests() []SearchRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SearchRequest(nil), f.requests...)
+}
+
+func (f *FakeSearcher) failure(req SearchRequest) FakeFailure {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	failure := FakeOK
+	if len(f.failures) > 0 {
+		failure, f.failures = f.failures[0], f.failures[1:]
+	}
+	f.mu.Unlock()
+
+	if failure == FakeOK && f.Fail != nil {
+		failure = f.Fail(req)
+	}
+	return failure
+}
+
+func fakeError(failure FakeFailure, req SearchRequest) error {
+	switch failure {
+	case FakeTimeout:
+		params := url.Values{}
+		params.Add("limit", strconv.Itoa(req.Limit))
+		params.Add("offset", strconv.Itoa(req.Offset))
+		params.Add("query", req.Query)
+		params.Add("order_field", req.OrderField)
+		params.Add("order_by", strconv.Itoa(req.OrderBy))
+		return fmt.Errorf("timeout for %s", params.Encode())
+	case FakeUnauthorized:
+		return fmt.Errorf("Bad AccessToken")
+	case FakeInternalError:
+		return fmt.Errorf("SearchServer fatal error")
+	case FakeBadJSON:
+		return fmt.Errorf("cant unpack result json: invalid character 'o' in literal null (expecting 'u')")
+	}
+	return nil
+}
+
+// search возвращает все записи, подходящие под запрос, с учётом сортировки и смещения
+func (f *FakeSearcher) search(req SearchRequest) ([]User, error) {
+	orderField, err := normalizeOrderField(req.OrderField)
+	if err != nil {
+		return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+	}
+
+	var found []User
+	for _, user := range f.Users {
+		if req.Query == "" || strings.Contains(user.Name, req.Query) || strings.Contains(user.About, req.Query) {
+			found = append(found, user)
+		}
+	}
+
+	if req.OrderBy != OrderByAsIs {
+		sort.SliceStable(found, func(i, j int) bool {
+			a, b := found[i], found[j]
+			if req.OrderBy == OrderByDesc {
+				a, b = b, a
+			}
+			switch orderField {
+			case "id":
+				return a.Id < b.Id
+			case "age":
+				return a.Age < b.Age
+			}
+			return a.Name < b.Name
+		})
+	}
+
+	if req.Offset >= len(found) {
+		return nil, nil
+	}
+	return found[req.Offset:], nil
+}
+
+// FindUsers ведёт себя как SearchClient.FindUsers против SearchServer с данными из Users
+func (f *FakeSearcher) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	req, err := normalizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := fakeError(f.failure(req), req); err != nil {
+		return nil, err
+	}
+
+	found, err := f.search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := SearchResponse{}
+	if len(found) > req.Limit {
+		found, result.NextPage = found[:req.Limit], true
+	}
+	result.Users = append(result.Users, found...)
+	return &result, nil
+}
+
+// StreamUsers ведёт себя как SearchClient.StreamUsers: Limit не учитывается
+func (f *FakeSearcher) StreamUsers(req SearchRequest, fn func(User) error) error {
+	if req.Offset < 0 {
+		return fmt.Errorf("offset must be > 0")
+	}
+	if err := fakeError(f.failure(req), req); err != nil {
+		return err
+	}
+
+	found, err := f.search(req)
+	if err != nil {
+		return err
+	}
+	for _, user := range found {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
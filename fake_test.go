@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func datasetSearcher(t *testing.T) *FakeSearcher {
+	users, err := loadUsers()
+	if err != nil {
+		t.Fatalf("cant load dataset: %s", err)
+	}
+	fake := &FakeSearcher{}
+	for _, row := range users.List {
+		fake.Users = append(fake.Users, row.User())
+	}
+	return fake
+}
+
+func TestFakeSearcher(t *testing.T) {
+	cases := []TestCase{
+		tResultCase1(),
+		tResultCase2(),
+		tResultCase4(),
+		tResultCase5(),
+		tResultCase6(),
+		tResultCase7(),
+		tResultCase8(),
+		tResultCase9(),
+		tResultCase10(),
+		tResultCase12(),
+		tResultCase13(),
+	}
+
+	var s UserSearcher = datasetSearcher(t)
+
+	for caseNum, item := range cases {
+		result, err := s.FindUsers(*item.Query)
+
+		if err != nil && !item.IsError {
+			t.Errorf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%d] expected error, got nil", caseNum)
+		}
+
+		if !reflect.DeepEqual(item.Result, result) {
+			t.Errorf("[%d] wrong result, expected %#v\n, got \n %#v", caseNum, item.Result, result)
+		}
+	}
+}
+
+func TestFakeSearcherOrderByAge(t *testing.T) {
+	fake := datasetSearcher(t)
+
+	for _, orderBy := range []int{OrderByAsc, OrderByDesc} {
+		result, err := fake.FindUsers(SearchRequest{Limit: 25, OrderField: "age", OrderBy: orderBy})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		for i := 1; i < len(result.Users); i++ {
+			prev, cur := result.Users[i-1].Age, result.Users[i].Age
+			if (orderBy == OrderByAsc && prev > cur) || (orderBy == OrderByDesc && prev < cur) {
+				t.Errorf("[%d] wrong order at %d: %d then %d", orderBy, i, prev, cur)
+			}
+		}
+	}
+}
+
+func TestFakeSearcherFailures(t *testing.T) {
+	fake := datasetSearcher(t)
+	fake.FailNext(FakeTimeout, FakeUnauthorized, FakeInternalError, FakeBadJSON)
+
+	expected := []string{
+		"timeout for ",
+		"Bad AccessToken",
+		"SearchServer fatal error",
+		"cant unpack result json",
+	}
+	for caseNum, prefix := range expected {
+		result, err := fake.FindUsers(SearchRequest{Limit: 1})
+		if err == nil || !strings.HasPrefix(err.Error(), prefix) || result != nil {
+			t.Errorf("[%d] expected %q error, got %#v, %#v", caseNum, prefix, result, err)
+		}
+	}
+
+	if _, err := fake.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Errorf("unexpected error after failures are used up: %#v", err)
+	}
+
+	fake.Fail = func(req SearchRequest) FakeFailure {
+		if req.Query == "broken" {
+			return FakeInternalError
+		}
+		return FakeOK
+	}
+	if _, err := fake.FindUsers(SearchRequest{Query: "broken"}); err == nil {
+		t.Errorf("expected error from Fail, got nil")
+	}
+	if err := fake.StreamUsers(SearchRequest{Query: "broken"}, func(User) error { return nil }); err == nil {
+		t.Errorf("expected error from Fail in stream, got nil")
+	}
+	if _, err := fake.FindUsers(SearchRequest{Query: "Aguilar"}); err != nil {
+		t.Errorf("unexpected error: %#v", err)
+	}
+
+	if requests := fake.Requests(); len(requests) != 8 || requests[7].Query != "Aguilar" || requests[7].Limit != 0 {
+		t.Errorf("wrong recorded requests %#v", requests)
+	}
+}
+
+func TestFakeSearcherStreamUsers(t *testing.T) {
+	cases := []struct {
+		Query   SearchRequest
+		Count   int
+		IsError bool
+	}{
+		{SearchRequest{}, 35, false},
+		{SearchRequest{Limit: 3, Offset: 30}, 5, false},
+		{SearchRequest{Offset: 40}, 0, false},
+		{SearchRequest{Query: "Aguilar"}, 1, false},
+		{SearchRequest{OrderField: "picture"}, 0, true},
+		{SearchRequest{Offset: -1}, 0, true},
+	}
+
+	fake := datasetSearcher(t)
+
+	for caseNum, item := range cases {
+		count := 0
+		err := fake.StreamUsers(item.Query, func(User) error {
+			count++
+			return nil
+		})
+
+		if err != nil && !item.IsError {
+			t.Errorf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%d] expected error, got nil", caseNum)
+		}
+		if count != item.Count {
+			t.Errorf("[%d] wrong count, expected %d, got %d", caseNum, item.Count, count)
+		}
+	}
+}
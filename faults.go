@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// заголовки, которыми в тестовом режиме можно заказать сбой для конкретного запроса
+const (
+	// задержка перед ответом, например 1500ms
+	FaultHeaderDelay = "X-Fault-Delay"
+	// статус, которым ответить вместо обработчика
+	FaultHeaderStatus = "X-Fault-Status"
+	// truncate - обрезать тело, malformed - испортить JSON
+	FaultHeaderBody = "X-Fault-Body"
+	// любое непустое значение - разорвать соединение без ответа
+	FaultHeaderDrop = "X-Fault-Drop"
+
+	FaultBodyTruncate  = "truncate"
+	FaultBodyMalformed = "malformed"
+)
+
+// FaultConfig описывает сбои, которые FaultInjector вносит в ответы.
+// Доли задаются числами от 0 до 1 и проверяются независимо для каждого запроса
+type FaultConfig struct {
+	// задержка каждого ответа: Latency плюс равномерная добавка до LatencyJitter
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	// медленный хвост: с долей LatencyTailRate к задержке добавляется LatencyTail
+	LatencyTail     time.Duration
+	LatencyTailRate float64
+
+	// доли запросов, на которые вместо обработчика отвечаем статусом, например {500: 0.1}
+	ErrorRates map[int]float64
+	// доля ответов с обрезанным телом
+	TruncateRate float64
+	// доля ответов с испорченным JSON
+	MalformedRate float64
+	// доля запросов, на которых соединение рвётся без ответа
+	DropRate float64
+
+	// разрешает заказывать сбои заголовками X-Fault-*, только для тестов
+	TestMode bool
+	// источник случайности, чтобы прогоны можно было повторить; по умолчанию зависит от времени
+	Rand *rand.Rand
+}
+
+// fault - сбои, выбранные для одного запроса
+type fault struct {
+	delay  time.Duration
+	status int
+	body   string
+	drop   bool
+	// ошибка в заголовках X-Fault-*, на запрос отвечаем 400
+	invalid string
+}
+
+type faultInjector struct {
+	next   http.Handler
+	config FaultConfig
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// FaultInjector оборачивает обработчик поиска и вносит в ответы задержки, ошибки,
+// обрезанные и испорченные тела и обрывы соединения согласно config
+func FaultInjector(next http.Handler, config FaultConfig) http.Handler {
+	source := config.Rand
+	if source == nil {
+		source = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &faultInjector{next: next, config: config, rand: source}
+}
+
+func (f *faultInjector) chance(rate float64) bool {
+	return rate > 0 && f.rand.Float64() < rate
+}
+
+func (f *faultInjector) pick(r *http.Request) fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	picked := fault{delay: f.config.Latency}
+	if f.config.LatencyJitter > 0 {
+		picked.delay += time.Duration(f.rand.Int63n(int64(f.config.LatencyJitter)))
+	}
+	if f.chance(f.config.LatencyTailRate) {
+		picked.delay += f.config.LatencyTail
+	}
+	// статусы перебираются по порядку, чтобы прогон с тем же Rand повторялся
+	statuses := make([]int, 0, len(f.config.ErrorRates))
+	for status := range f.config.ErrorRates {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		if validFaultStatus(status) && picked.status == 0 && f.chance(f.config.ErrorRates[status]) {
+			picked.status = status
+		}
+	}
+	switch {
+	case f.chance(f.config.TruncateRate):
+		picked.body = FaultBodyTruncate
+	case f.chance(f.config.MalformedRate):
+		picked.body = FaultBodyMalformed
+	}
+	picked.drop = f.chance(f.config.DropRate)
+
+	if f.config.TestMode {
+		if value := r.Header.Get(FaultHeaderDelay); value != "" {
+			if delay, err := time.ParseDuration(value); err == nil && delay >= 0 {
+				picked.delay = delay
+			} else {
+				picked.invalid = FaultHeaderDelay + " must be a non-negative duration like 250ms, got " + strconv.Quote(value)
+			}
+		}
+		if value := r.Header.Get(FaultHeaderStatus); value != "" {
+			if status, err := strconv.Atoi(value); err == nil && validFaultStatus(status) {
+				picked.status = status
+			} else {
+				picked.invalid = FaultHeaderStatus + " must be a status code from 100 to 999, got " + strconv.Quote(value)
+			}
+		}
+		if value := r.Header.Get(FaultHeaderBody); value != "" {
+			if value == FaultBodyTruncate || value == FaultBodyMalformed {
+				picked.body = value
+			} else {
+				picked.invalid = FaultHeaderBody + " must be " + FaultBodyTruncate + " or " + FaultBodyMalformed + ", got " + strconv.Quote(value)
+			}
+		}
+		if r.Header.Get(FaultHeaderDrop) != "" {
+			picked.drop = true
+		}
+	}
+	return picked
+}
+
+// validFaultStatus - статусы, которые можно передать в WriteHeader: на остальных он паникует
+func validFaultStatus(status int) bool {
+	return status >= 100 && status <= 999
+}
+
+func (f *faultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	picked := f.pick(r)
+	if picked.invalid != "" {
+		http.Error(w, picked.invalid, http.StatusBadRequest)
+		return
+	}
+
+	if picked.delay > 0 {
+		select {
+		case <-time.After(picked.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if picked.drop {
+		// сервер закрывает соединение, не отправив ответ
+		panic(http.ErrAbortHandler)
+	}
+	if picked.status != 0 {
+		w.WriteHeader(picked.status)
+		return
+	}
+	if picked.body == "" {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	buffer := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	f.next.ServeHTTP(buffer, r)
+
+	body := buffer.body.Bytes()
+	switch picked.body {
+	case FaultBodyTruncate:
+		body = body[:len(body)/2]
+	case FaultBodyMalformed:
+		body = append(body, '}')
+	}
+	for name, values := range buffer.header {
+		w.Header()[name] = values
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(buffer.status)
+	w.Write(body)
+}
+
+// bufferedResponse копит ответ обработчика, чтобы его можно было испортить перед отправкой
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status, b.wroteHeader = status, true
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(data)
+}
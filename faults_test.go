@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// headerTransport добавляет заголовки ко всем запросам клиента
+type headerTransport http.Header
+
+func (h headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range h {
+		req.Header[name] = values
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFaultInjectorHeaders(t *testing.T) {
+	cases := []struct {
+		Header http.Header
+		Error  string
+	}{
+		{http.Header{}, ""},
+		{http.Header{FaultHeaderStatus: {"500"}}, "SearchServer fatal error"},
+		{http.Header{FaultHeaderStatus: {"401"}}, "Bad AccessToken"},
+		{http.Header{FaultHeaderBody: {FaultBodyTruncate}}, "cant unpack result json"},
+		{http.Header{FaultHeaderBody: {FaultBodyMalformed}}, "cant unpack result json"},
+		{http.Header{FaultHeaderDrop: {"1"}}, "unknown error"},
+		{http.Header{FaultHeaderDelay: {"1500ms"}}, "timeout for"},
+	}
+
+	ts := httptest.NewServer(FaultInjector(http.HandlerFunc(SearchServer), FaultConfig{TestMode: true}))
+
+	for caseNum, item := range cases {
+		s := &SearchClient{
+			AccessToken: serverAccessToken,
+			URL:         ts.URL,
+			Version:     APIVersion1,
+			Transport:   headerTransport(item.Header),
+		}
+		result, err := s.FindUsers(*tResultCase2().Query)
+
+		if item.Error == "" {
+			if err != nil || len(result.Users) != 1 {
+				t.Errorf("[%d] unexpected result %#v, %#v", caseNum, result, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), item.Error) {
+			t.Errorf("[%d] expected %q error, got %#v", caseNum, item.Error, err)
+		}
+	}
+	ts.Close()
+}
+
+func TestFaultInjectorRejectsBadHeaders(t *testing.T) {
+	handler := FaultInjector(http.HandlerFunc(SearchServer), FaultConfig{
+		TestMode:   true,
+		ErrorRates: map[int]float64{1000: 1, 42: 1},
+	})
+	cases := []struct {
+		header, value string
+	}{
+		{FaultHeaderStatus, "1000"},
+		{FaultHeaderStatus, "99"},
+		{FaultHeaderStatus, "-1"},
+		{FaultHeaderStatus, "abc"},
+		{FaultHeaderDelay, "100"},
+		{FaultHeaderDelay, "soon"},
+		{FaultHeaderDelay, "-1s"},
+		{FaultHeaderBody, "truncated"},
+		{FaultHeaderBody, "garbage"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/?limit=1", nil)
+		r.Header.Set("AccessToken", serverAccessToken)
+		r.Header.Set(c.header, c.value)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), c.header) {
+			t.Errorf("%s: %s: expected 400, got %d %s", c.header, c.value, w.Code, w.Body.String())
+		}
+	}
+
+	// недопустимые статусы в ErrorRates пропускаются
+	r := httptest.NewRequest("GET", "/?limit=1", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected untouched response, got %d", w.Code)
+	}
+}
+
+func TestFaultInjectorIgnoresHeadersOutsideTestMode(t *testing.T) {
+	handler := FaultInjector(http.HandlerFunc(SearchServer), FaultConfig{})
+
+	r := httptest.NewRequest("GET", "/?query=Aguilar", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	r.Header.Set(FaultHeaderStatus, "500")
+	r.Header.Set(FaultHeaderBody, FaultBodyMalformed)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !strings.HasSuffix(w.Body.String(), "]") {
+		t.Errorf("expected untouched response, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFaultInjectorRates(t *testing.T) {
+	handler := FaultInjector(http.HandlerFunc(SearchServer), FaultConfig{
+		ErrorRates:    map[int]float64{http.StatusInternalServerError: 0.2, http.StatusServiceUnavailable: 0.1},
+		MalformedRate: 0.1,
+		Rand:          rand.New(rand.NewSource(1)),
+	})
+
+	counts := map[int]int{}
+	malformed := 0
+	for i := 0; i < 1000; i++ {
+		r := httptest.NewRequest("GET", "/?limit=1", nil)
+		r.Header.Set("AccessToken", serverAccessToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		counts[w.Code]++
+		if w.Code == http.StatusOK && strings.HasSuffix(w.Body.String(), "}") {
+			malformed++
+		}
+	}
+
+	// 500 в 20% запросов, 503 в 10% оставшихся, испорченный JSON в 10% успешных
+	if counts[http.StatusInternalServerError] < 150 || counts[http.StatusInternalServerError] > 250 {
+		t.Errorf("wrong 500 count %d", counts[http.StatusInternalServerError])
+	}
+	if counts[http.StatusServiceUnavailable] < 50 || counts[http.StatusServiceUnavailable] > 110 {
+		t.Errorf("wrong 503 count %d", counts[http.StatusServiceUnavailable])
+	}
+	if malformed < 40 || malformed > 110 {
+		t.Errorf("wrong malformed count %d", malformed)
+	}
+}
+
+func TestFaultInjectorLatency(t *testing.T) {
+	handler := FaultInjector(http.HandlerFunc(SearchServer), FaultConfig{
+		Latency:         20 * time.Millisecond,
+		LatencyJitter:   10 * time.Millisecond,
+		LatencyTail:     100 * time.Millisecond,
+		LatencyTailRate: 1,
+	})
+
+	r := httptest.NewRequest("GET", "/?limit=1", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if elapsed := time.Since(start); elapsed < 120*time.Millisecond {
+		t.Errorf("expected at least 120ms delay, got %s", elapsed)
+	}
+}
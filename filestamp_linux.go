@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func init() {
+	fileChangeTime = func(info os.FileInfo) int64 {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			return stat.Ctim.Nano()
+		}
+		return 0
+	}
+}
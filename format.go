@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXML  = "xml"
+
+	MimeCSV = "text/csv"
+	MimeXML = "application/xml"
+)
+
+// userColumns - колонки, которые можно запросить параметром fields, по именам элементов UserXml
+var userColumns = map[string]func(UserXml) string{
+	"id":         func(u UserXml) string { return strconv.Itoa(u.ID) },
+	"guid":       func(u UserXml) string { return u.GUID },
+	"isActive":   func(u UserXml) string { return strconv.FormatBool(u.Active) },
+	"balance":    func(u UserXml) string { return u.Balance },
+	"picture":    func(u UserXml) string { return u.Picture },
+	"age":        func(u UserXml) string { return strconv.Itoa(u.Age) },
+	"eyeColor":   func(u UserXml) string { return u.EyeColor },
+	"first_name": func(u UserXml) string { return u.FirstName },
+	"last_name":  func(u UserXml) string { return u.LastName },
+	"name":       func(u UserXml) string { return u.FirstName + " " + u.LastName },
+	"gender":     func(u UserXml) string { return u.Gender },
+	"company":    func(u UserXml) string { return u.Company },
+	"email":      func(u UserXml) string { return u.Email },
+	"phone":      func(u UserXml) string { return u.Phone },
+	"address":    func(u UserXml) string { return u.Address },
+	"about":      func(u UserXml) string { return u.About },
+}
+
+var (
+	// по умолчанию CSV повторяет поля User
+	defaultCSVColumns = []string{"id", "name", "age", "about", "gender"}
+	// а XML - строки dataset.xml в том же порядке элементов
+	defaultXMLColumns = []string{"id", "guid", "isActive", "balance", "picture", "age", "eyeColor", "first_name", "last_name", "gender", "company", "email", "phone", "address", "about"}
+)
+
+// negotiateFormat выбирает формат ответа: параметр format важнее заголовка Accept
+func negotiateFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.ToLower(format)
+	}
+	// путь с версией обещает её JSON, поэтому Accept браузера вроде application/xml;q=0.9 его не меняет
+	if versionPrefix(r) != "" {
+		return formatJSON
+	}
+	return negotiateAccept(r.Header.Get("Accept"), map[string]string{
+		"application/json": formatJSON,
+		MimeSearchV1:       formatJSON,
+		MimeSearchV2:       formatJSON,
+		MimeCSV:            formatCSV,
+		MimeXML:            formatXML,
+		"text/xml":         formatXML,
+	}, formatJSON)
+}
+
+func parseColumns(r *http.Request, defaults []string) ([]string, error) {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		return defaults, nil
+	}
+	columns := strings.Split(fields, ",")
+	for i, column := range columns {
+		columns[i] = strings.TrimSpace(column)
+		if _, ok := userColumns[columns[i]]; !ok {
+			return nil, &paramError{Field: "fields", Err: unknownColumnError(columns[i])}
+		}
+	}
+	return columns, nil
+}
+
+type unknownColumnError string
+
+func (e unknownColumnError) Error() string {
+	return "unknown column " + string(e)
+}
+
+// FormatUsers отдаёт страницу результатов в CSV или XML. Колонки выбираются параметром fields,
+// признак следующей страницы и общее количество уходят в заголовках X-Next-Page и X-Total-Count
+func FormatUsers(w http.ResponseWriter, r *http.Request, format string) {
+	if !authorized(r) {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusUnauthorized, SearchErrorDetail{Code: ErrorCodeBadAccessToken, Message: "bad access token"})
+		return
+	}
+
+	store, err := requestStore(r)
+	if err != nil {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusInternalServerError, SearchErrorDetail{Code: ErrorCodeInternal, Message: "dataset is unavailable"})
+		return
+	}
+
+	var columns []string
+	switch format {
+	case formatCSV:
+		columns, err = parseColumns(r, defaultCSVColumns)
+	case formatXML:
+		columns, err = parseColumns(r, defaultXMLColumns)
+	default:
+		err = &paramError{Field: "format", Err: errUnknownFormat}
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusBadRequest, errorDetailV2(err))
+		return
+	}
+
+	params, err := parseSearchParams(r)
+	if err == nil && params.Limit < 0 {
+		err = &paramError{Field: "limit", Err: errNegative}
+	}
+	if err == nil && params.Offset < 0 {
+		err = &paramError{Field: "offset", Err: errNegative}
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusBadRequest, errorDetailV2(err))
+		return
+	}
+
+	if notModified(w, r, store, format+":"+strings.Join(columns, ","), params) {
+		return
+	}
+
+	req := params.request()
+	req.Limit++
+	rows, err := store.FindRows(req)
+	var total int
+	if err == nil {
+		total, err = store.CountUsers(params.Query)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", MimeSearchV2)
+		writeErrorV2(w, http.StatusInternalServerError, SearchErrorDetail{Code: ErrorCodeInternal, Message: "dataset is unavailable"})
+		return
+	}
+	nextPage := len(rows) > params.Limit
+	if nextPage {
+		rows = rows[:params.Limit]
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("X-Next-Page", strconv.FormatBool(nextPage))
+
+	if format == formatCSV {
+		writeCSV(w, rows, columns)
+	} else {
+		writeXML(w, rows, columns)
+	}
+}
+
+func writeCSV(w http.ResponseWriter, rows []UserXml, columns []string) {
+	w.Header().Set("Content-Type", MimeCSV+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	encodeCSV(w, rows, columns)
+}
+
+// encodeCSV пишет строку заголовков и записи в выбранных колонках
+func encodeCSV(w io.Writer, rows []UserXml, columns []string) error {
+	writer := csv.NewWriter(w)
+	writer.Write(columns)
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			record[i] = userColumns[column](row)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeXML(w http.ResponseWriter, rows []UserXml, columns []string) {
+	w.Header().Set("Content-Type", MimeXML+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	root := xml.StartElement{Name: xml.Name{Local: "root"}}
+	row := xml.StartElement{Name: xml.Name{Local: "row"}}
+
+	encoder.EncodeToken(root)
+	for _, userEnt := range rows {
+		encoder.EncodeToken(row)
+		for _, column := range columns {
+			encoder.EncodeElement(userColumns[column](userEnt), xml.StartElement{Name: xml.Name{Local: column}})
+		}
+		encoder.EncodeToken(row.End())
+	}
+	encoder.EncodeToken(root.End())
+	encoder.Flush()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFormatUsersCSV(t *testing.T) {
+	cases := []struct {
+		URL      string
+		Accept   string
+		Body     string
+		NextPage string
+		Total    string
+	}{
+		{
+			URL:      "/?format=csv&query=Aguilar",
+			Body:     "id,name,age,about,gender\n2,Brooks Aguilar,25,\"Velit ullamco est aliqua voluptate nisi do. Voluptate magna anim qui cillum aliqua sint veniam reprehenderit consectetur enim. Laborum dolore ut eiusmod ipsum ad anim est do tempor culpa ad do tempor. Nulla id aliqua dolore dolore adipisicing.\n\",male\n",
+			NextPage: "false",
+			Total:    "1",
+		},
+		{
+			URL:      "/?fields=id,email,isActive&limit=2&order_field=id&order_by=-1",
+			Accept:   "text/csv",
+			Body:     "id,email,isActive\n0,boydwolf@hopeli.com,false\n1,hildamayer@quintity.com,false\n",
+			NextPage: "true",
+			Total:    "35",
+		},
+		{
+			URL:      "/v2?format=CSV&fields=id,%20name&limit=1&offset=34&order_field=id&order_by=-1",
+			Accept:   MimeSearchV2,
+			Body:     "id,name\n34,Kane Sharp\n",
+			NextPage: "false",
+			Total:    "35",
+		},
+	}
+
+	for caseNum, item := range cases {
+		r := httptest.NewRequest("GET", item.URL, nil)
+		r.Header.Set("AccessToken", serverAccessToken)
+		r.Header.Set("Accept", item.Accept)
+		w := httptest.NewRecorder()
+		SearchServer(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("[%d] wrong status %d: %s", caseNum, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+			t.Errorf("[%d] wrong content type %s", caseNum, ct)
+		}
+		if w.Body.String() != item.Body {
+			t.Errorf("[%d] wrong body, expected %q, got %q", caseNum, item.Body, w.Body.String())
+		}
+		if w.Header().Get("X-Next-Page") != item.NextPage || w.Header().Get("X-Total-Count") != item.Total {
+			t.Errorf("[%d] wrong paging headers %v", caseNum, w.Header())
+		}
+	}
+}
+
+func TestFormatUsersXML(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?limit=3&order_field=id&order_by=-1", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	r.Header.Set("Accept", "application/xml")
+	w := httptest.NewRecorder()
+	SearchServer(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+		t.Errorf("wrong content type %s", ct)
+	}
+	result := Users{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("cant unpack xml: %s", err)
+	}
+	users, _ := loadUsers()
+	if !reflect.DeepEqual(users.List[:3], result.List) {
+		t.Errorf("wrong rows, expected %#v\n, got \n %#v", users.List[:3], result.List)
+	}
+
+	r = httptest.NewRequest("GET", "/?format=xml&fields=id,first_name&query=Aguilar", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	w = httptest.NewRecorder()
+	SearchServer(w, r)
+
+	expected := xml.Header + "<root>\n  <row>\n    <id>2</id>\n    <first_name>Brooks</first_name>\n  </row>\n</root>"
+	if w.Body.String() != expected {
+		t.Errorf("wrong body, expected %q, got %q", expected, w.Body.String())
+	}
+}
+
+func TestFormatVersionedPath(t *testing.T) {
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	for _, path := range []string{"/v1", "/v1/", "/v2"} {
+		r := httptest.NewRequest("GET", path+"?limit=1", nil)
+		r.Header.Set("AccessToken", serverAccessToken)
+		r.Header.Set("Accept", browser)
+		w := httptest.NewRecorder()
+		SearchServer(w, r)
+		if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); w.Code != http.StatusOK || (mediaType != MimeSearchV1 && mediaType != MimeSearchV2) {
+			t.Errorf("%s: expected JSON of the path version, got %d %s", path, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// без префикса Accept по-прежнему выбирает XML, а на пути с версией формат задаётся только параметром
+	r := httptest.NewRequest("GET", "/?limit=1", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	r.Header.Set("Accept", browser)
+	w := httptest.NewRecorder()
+	SearchServer(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+		t.Errorf("expected XML without version prefix, got %s", ct)
+	}
+	r = httptest.NewRequest("GET", "/v1?limit=1&format=csv", nil)
+	r.Header.Set("AccessToken", serverAccessToken)
+	w = httptest.NewRecorder()
+	SearchServer(w, r)
+	if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType != MimeCSV {
+		t.Errorf("expected CSV by format parameter, got %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestFormatUsersErrors(t *testing.T) {
+	cases := []struct {
+		URL    string
+		Token  string
+		Status int
+		Error  SearchErrorDetail
+	}{
+		{"/?format=yaml", serverAccessToken, http.StatusBadRequest, SearchErrorDetail{Code: ErrorCodeBadParam, Message: "must be one of json, csv, xml", Field: "format"}},
+		{"/?format=csv&fields=id,password", serverAccessToken, http.StatusBadRequest, SearchErrorDetail{Code: ErrorCodeBadParam, Message: "unknown column password", Field: "fields"}},
+		{"/?format=xml&order_field=picture", serverAccessToken, http.StatusBadRequest, SearchErrorDetail{Code: ErrorCodeBadOrderField, Message: "order_field must be one of id, age, name", Field: "order_field"}},
+		{"/?format=csv&limit=-1", serverAccessToken, http.StatusBadRequest, SearchErrorDetail{Code: ErrorCodeBadParam, Message: "must not be negative", Field: "limit"}},
+		{"/?format=csv&offset=-1", serverAccessToken, http.StatusBadRequest, SearchErrorDetail{Code: ErrorCodeBadParam, Message: "must not be negative", Field: "offset"}},
+		{"/?format=csv", "bad", http.StatusUnauthorized, SearchErrorDetail{Code: ErrorCodeBadAccessToken, Message: "bad access token"}},
+	}
+
+	for caseNum, item := range cases {
+		r := httptest.NewRequest("GET", item.URL, nil)
+		r.Header.Set("AccessToken", item.Token)
+		w := httptest.NewRecorder()
+		SearchServer(w, r)
+
+		if w.Code != item.Status {
+			t.Errorf("[%d] wrong status, expected %d, got %d", caseNum, item.Status, w.Code)
+		}
+		errResp := SearchErrorResponseV2{}
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if !reflect.DeepEqual(item.Error, errResp.Error) {
+			t.Errorf("[%d] wrong error, expected %#v, got %#v", caseNum, item.Error, errResp.Error)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func FuzzHandleRequest(f *testing.F) {
+	f.Add("10", "0", "", "", "0")
+	f.Add("3", "33", "Aguilar", "Name", "1")
+	f.Add("-1", "-5", "Nulla", "AGE", "-1")
+	f.Add("abc", "1", "", "id", "2")
+	f.Add("999999999999999999999", "0", "", "", "")
+	f.Add("", "", "\x00", "picture", "x")
+
+	f.Fuzz(func(t *testing.T, limit, offset, query, orderField, orderBy string) {
+		params := url.Values{}
+		params.Set("limit", limit)
+		params.Set("offset", offset)
+		params.Set("query", query)
+		params.Set("order_field", orderField)
+		params.Set("order_by", orderBy)
+		r := httptest.NewRequest("GET", "/?"+params.Encode(), nil)
+
+		result, err := handleRequest(r)
+		if err != nil {
+			if result != nil {
+				t.Errorf("expected no users with error %s, got %d", err, len(result))
+			}
+			return
+		}
+
+		parsedLimit, _ := strconv.Atoi(limit)
+		if limit != "" && parsedLimit >= 0 && len(result) > parsedLimit {
+			t.Errorf("limit %s exceeded: %d users", limit, len(result))
+		}
+		for _, user := range result {
+			if query != "" && !strings.Contains(user.Name, query) && !strings.Contains(user.About, query) {
+				t.Errorf("user %d does not match query %q", user.Id, query)
+			}
+		}
+	})
+}
+
+// roundTripFunc позволяет подставить ответ сервера без сети
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func FuzzFindUsersDecode(f *testing.F) {
+	f.Add(200, "", []byte(`[{"Id":1,"Name":"a"}]`), 1)
+	f.Add(200, "", []byte(`null`), 0)
+	f.Add(200, MimeSearchV2, []byte(`{"users":[{"Id":1}],"paging":{"next_page":true}}`), 5)
+	f.Add(400, "", []byte(`{"error":"ErrorBadOrderField"}`), 1)
+	f.Add(400, MimeSearchV2, []byte(`{"error":{"code":"ErrorBadParam","details":[{"field":"limit"}]}}`), 1)
+	f.Add(401, "", []byte(``), 1)
+	f.Add(500, MimeSearchV2, []byte(`notajson`), 1)
+	f.Add(200, "", []byte(`[{"Id":1},{"Id":2},{"Id":3}]`), 2)
+
+	f.Fuzz(func(t *testing.T, status int, contentType string, body []byte, limit int) {
+		if status < 100 || status > 999 {
+			return
+		}
+		s := &SearchClient{
+			URL: "http://search.invalid",
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: status,
+					Header:     http.Header{"Content-Type": {contentType}},
+					Body:       ioutil.NopCloser(bytes.NewReader(body)),
+					Request:    req,
+				}, nil
+			}),
+		}
+
+		result, err := s.FindUsers(SearchRequest{Limit: limit})
+		if err != nil {
+			if result != nil {
+				t.Errorf("expected nil result with error %s", err)
+			}
+			return
+		}
+		if limit < 0 {
+			t.Errorf("expected error for negative limit %d", limit)
+		}
+		if len(result.Users) > limit || len(result.Users) > 25 {
+			t.Errorf("got %d users for limit %d", len(result.Users), limit)
+		}
+	})
+}
+
+// collectPages листает выдачу страницами по limit и проверяет NextPage на каждой.
+// Страница может оказаться меньше limit из-за ограничения в 25 записей, поэтому смещение растёт на её размер
+func collectPages(t *testing.T, s *SearchClient, req SearchRequest) []User {
+	var all []User
+	for {
+		result, err := s.FindUsers(req)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %s", req, err)
+		}
+		if len(result.Users) > req.Limit || len(result.Users) > 25 {
+			t.Errorf("%+v: page has %d users", req, len(result.Users))
+		}
+		all = append(all, result.Users...)
+		req.Offset += len(result.Users)
+		if !result.NextPage {
+			return all
+		}
+		if len(result.Users) == 0 {
+			t.Fatalf("%+v: NextPage on empty page", req)
+		}
+	}
+}
+
+func TestSearchPagingProperties(t *testing.T) {
+	queries := []string{"", "Aguilar", "Nulla", "et", "nobody"}
+	fields := []string{"", "id", "age", "name"}
+	orders := []int{OrderByAsc, OrderByAsIs, OrderByDesc}
+
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+	s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+
+	property := func(limit uint8, queryIdx, fieldIdx, orderIdx uint8) bool {
+		req := SearchRequest{
+			Limit:      int(limit)%30 + 1,
+			Query:      queries[int(queryIdx)%len(queries)],
+			OrderField: fields[int(fieldIdx)%len(fields)],
+			OrderBy:    orders[int(orderIdx)%len(orders)],
+		}
+
+		expected := []User{}
+		if err := s.StreamUsers(req, func(user User) error {
+			expected = append(expected, user)
+			return nil
+		}); err != nil {
+			t.Errorf("%+v: unexpected export error: %s", req, err)
+			return false
+		}
+
+		pages := collectPages(t, s, req)
+		seen := map[int]bool{}
+		for _, user := range pages {
+			if seen[user.Id] {
+				t.Errorf("%+v: user %d is on several pages", req, user.Id)
+				return false
+			}
+			seen[user.Id] = true
+		}
+		if len(pages) != len(expected) || (len(expected) > 0 && !reflect.DeepEqual(pages, expected)) {
+			t.Errorf("%+v: pages do not add up to the full result: %d vs %d users", req, len(pages), len(expected))
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 50, Rand: rand.New(rand.NewSource(1))}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSearchNextPageBoundaries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+	s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+
+	const total = 35
+	for limit := 0; limit <= 30; limit++ {
+		for _, offset := range []int{0, total - limit - 1, total - limit, total - limit + 1, total} {
+			if offset < 0 {
+				continue
+			}
+			result, err := s.FindUsers(SearchRequest{Limit: limit, Offset: offset, OrderField: "id", OrderBy: OrderByAsc})
+			if err != nil {
+				t.Fatalf("limit %d offset %d: unexpected error: %s", limit, offset, err)
+			}
+
+			pageSize := limit
+			if pageSize > 25 {
+				pageSize = 25
+			}
+			expectedUsers := total - offset
+			if expectedUsers > pageSize {
+				expectedUsers = pageSize
+			}
+			if expectedUsers < 0 {
+				expectedUsers = 0
+			}
+			if len(result.Users) != expectedUsers {
+				t.Errorf("limit %d offset %d: expected %d users, got %d", limit, offset, expectedUsers, len(result.Users))
+			}
+			if nextPage := offset+pageSize < total; result.NextPage != nextPage {
+				t.Errorf("limit %d offset %d: expected NextPage %v, got %v", limit, offset, nextPage, result.NextPage)
+			}
+		}
+	}
+}
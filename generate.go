@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// DatasetConfig - параметры синтетического датасета
+type DatasetConfig struct {
+	Rows int
+	// одинаковый Seed даёт одинаковый датасет
+	Seed int64
+	// распределения значений, по умолчанию DefaultDistribution
+	Distribution *DatasetDistribution
+
+	// доли строк с пограничными случаями, 0 - не генерировать
+	UnicodeNamesRate   float64
+	EmptyFieldsRate    float64
+	DuplicateNamesRate float64
+}
+
+// DatasetDistribution - распределения значений в синтетическом датасете
+type DatasetDistribution struct {
+	// возраст равномерно распределён в [MinAge, MaxAge]
+	MinAge int
+	MaxAge int
+	// доли женщин и активных пользователей
+	FemaleRatio float64
+	ActiveRatio float64
+}
+
+var DefaultDistribution = DatasetDistribution{MinAge: 18, MaxAge: 60, FemaleRatio: 0.5, ActiveRatio: 0.5}
+
+// Validate проверяет, что параметры генерации имеют смысл
+func (c DatasetConfig) Validate() error {
+	if c.Rows < 0 {
+		return fmt.Errorf("rows must not be negative")
+	}
+	if d := c.Distribution; d != nil {
+		if d.MinAge < 0 || d.MinAge > d.MaxAge {
+			return fmt.Errorf("age range %d..%d is invalid", d.MinAge, d.MaxAge)
+		}
+		if d.FemaleRatio < 0 || d.FemaleRatio > 1 || d.ActiveRatio < 0 || d.ActiveRatio > 1 {
+			return fmt.Errorf("ratios must be between 0 and 1")
+		}
+	}
+	for _, rate := range []float64{c.UnicodeNamesRate, c.EmptyFieldsRate, c.DuplicateNamesRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("edge case rates must be between 0 and 1")
+		}
+	}
+	return nil
+}
+
+// datasetRow - строка dataset.xml со всеми полями, включая те, которых нет в UserXml
+type datasetRow struct {
+	XMLName xml.Name `xml:"row"`
+	UserXml
+	Registered    string `xml:"registered"`
+	FavoriteFruit string `xml:"favoriteFruit"`
+}
+
+var (
+	maleNames   = []string{"Boyd", "Brooks", "Kane", "Terrell", "Whitley", "Bell", "Cruz", "Gates", "Henderson", "Jennings", "Owen", "Palmer", "Rose", "Dillard", "Cohen", "Glenn", "Hobbs", "Nicholson"}
+	femaleNames = []string{"Hilda", "Twila", "Christy", "Annie", "Allison", "Beulah", "Clarissa", "Everett", "Gonzalez", "Johns", "Lowery", "Mable", "Nellie", "Rebekah", "Leann", "Cora", "Kim", "Wendy"}
+	lastNames   = []string{"Wolf", "Mayer", "Aguilar", "Sharp", "Snow", "Knapp", "Valdez", "Osborn", "Bauer", "Davidson", "Hall", "Travis", "Finch", "Blair", "Sawyer", "Mccarty", "Day", "Salas", "Duran", "Wilkerson"}
+	companies   = []string{"HOPELI", "QUINTITY", "ZILLACOM", "COLAIRE", "EXTRAWEAR", "PHUEL", "ENERVATE", "AQUASSEUR", "OBLIQ", "GEEKOLOGY", "KIGGLE", "VIAGRAND", "MEDIFAX", "XURBAN", "ORBEAN"}
+	streets     = []string{"Winthrop Street", "Friel Place", "Williams Court", "Ovington Avenue", "Bushwick Place", "Ocean Avenue", "Elm Place", "Sandford Street", "Veronica Place", "Kent Street"}
+	cities      = []string{"Edneyville", "Loyalhanna", "Vandiver", "Stollings", "Ribera", "Sunwest", "Hoehne", "Gardiner", "Cliffside", "Waterford"}
+	states      = []string{"Mississippi", "Kansas", "North Carolina", "Oregon", "Virginia", "Texas", "Maine", "Utah", "Ohio", "Nevada"}
+	eyeColors   = []string{"blue", "brown", "green"}
+	fruits      = []string{"apple", "banana", "strawberry"}
+	// имена для пограничного случая с Unicode
+	unicodeFirstNames = []string{"Zoë", "Łukasz", "Søren", "José", "Ирина", "明", "Ólafur", "Chloé", "Ağca", "Þóra"}
+	unicodeLastNames  = []string{"Müller", "Ødegaard", "Иванова", "García", "Nguyễn", "O'Brien", "van der Berg", "Strauß", "李", "Čapek"}
+
+	loremWords = strings.Fields("lorem ipsum dolor sit amet consectetur adipisicing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris nisi aliquip ex ea commodo consequat duis aute irure in reprehenderit voluptate velit esse cillum fugiat nulla pariatur excepteur sint occaecat cupidatat non proident sunt culpa qui officia deserunt mollit anim id est laborum")
+)
+
+// GenerateDataset пишет в w синтетический датасет в формате dataset.xml. Строки пишутся по одной,
+// поэтому размер датасета ограничен только местом под результат
+func GenerateDataset(w io.Writer, config DatasetConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	distribution := DefaultDistribution
+	if config.Distribution != nil {
+		distribution = *config.Distribution
+	}
+
+	rnd := rand.New(rand.NewSource(config.Seed))
+	buffered := bufio.NewWriter(w)
+
+	buffered.WriteString(xml.Header + "<root>\n")
+	encoder := xml.NewEncoder(buffered)
+	encoder.Indent("  ", "  ")
+	// последние имена, из которых берутся дубликаты
+	var recent []datasetRow
+	for id := 0; id < config.Rows; id++ {
+		row := randomRow(rnd, id, distribution)
+
+		if rnd.Float64() < config.UnicodeNamesRate {
+			row.FirstName, row.LastName = pick(rnd, unicodeFirstNames), pick(rnd, unicodeLastNames)
+		}
+		if len(recent) > 0 && rnd.Float64() < config.DuplicateNamesRate {
+			original := recent[rnd.Intn(len(recent))]
+			row.FirstName, row.LastName, row.Gender = original.FirstName, original.LastName, original.Gender
+		}
+		if rnd.Float64() < config.EmptyFieldsRate {
+			emptyFields(rnd, &row)
+		}
+
+		if len(recent) < 100 {
+			recent = append(recent, row)
+		} else {
+			recent[id%100] = row
+		}
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	buffered.WriteString("\n</root>\n")
+	return buffered.Flush()
+}
+
+// emptyFields очищает от одного до трёх случайных текстовых полей строки
+func emptyFields(rnd *rand.Rand, row *datasetRow) {
+	fields := []*string{
+		&row.GUID, &row.Balance, &row.Picture, &row.EyeColor, &row.FirstName, &row.LastName, &row.Gender,
+		&row.Company, &row.Email, &row.Phone, &row.Address, &row.About, &row.Registered, &row.FavoriteFruit,
+	}
+	for i := 1 + rnd.Intn(3); i > 0; i-- {
+		*fields[rnd.Intn(len(fields))] = ""
+	}
+}
+
+func pick(rnd *rand.Rand, values []string) string {
+	return values[rnd.Intn(len(values))]
+}
+
+func randomRow(rnd *rand.Rand, id int, distribution DatasetDistribution) datasetRow {
+	row := datasetRow{}
+	row.ID = id
+	row.GUID = fmt.Sprintf("%08x-%04x-4%03x-%04x-%012x", rnd.Uint32(), rnd.Intn(1<<16), rnd.Intn(1<<12), 0x8000|rnd.Intn(1<<14), rnd.Int63n(1<<48))
+	row.Active = rnd.Float64() < distribution.ActiveRatio
+	row.Balance = fmt.Sprintf("$%d,%03d.%02d", 1+rnd.Intn(3), rnd.Intn(1000), rnd.Intn(100))
+	row.Picture = "http://placehold.it/32x32"
+	row.Age = distribution.MinAge + rnd.Intn(distribution.MaxAge-distribution.MinAge+1)
+	row.EyeColor = pick(rnd, eyeColors)
+
+	if rnd.Float64() < distribution.FemaleRatio {
+		row.FirstName, row.Gender = pick(rnd, femaleNames), "female"
+	} else {
+		row.FirstName, row.Gender = pick(rnd, maleNames), "male"
+	}
+	row.LastName = pick(rnd, lastNames)
+	row.Company = pick(rnd, companies)
+	row.Email = strings.ToLower(row.FirstName+row.LastName) + "@" + strings.ToLower(row.Company) + ".com"
+	row.Phone = fmt.Sprintf("+1 (%03d) %03d-%04d", 800+rnd.Intn(200), rnd.Intn(1000), rnd.Intn(10000))
+	row.Address = fmt.Sprintf("%d %s, %s, %s, %d", 100+rnd.Intn(900), pick(rnd, streets), pick(rnd, cities), pick(rnd, states), 1000+rnd.Intn(9000))
+	row.About = randomAbout(rnd)
+
+	registered := time.Date(2014, 1, 1, 0, 0, 0, 0, time.FixedZone("", -3*3600)).Add(time.Duration(rnd.Int63n(int64(4 * 365 * 24 * time.Hour))))
+	row.Registered = registered.Format("2006-01-02T15:04:05 -07:00")
+	row.FavoriteFruit = pick(rnd, fruits)
+	return row
+}
+
+// randomAbout собирает несколько предложений из lorem ipsum, как в поле about исходного датасета
+func randomAbout(rnd *rand.Rand) string {
+	var about strings.Builder
+	sentences := 3 + rnd.Intn(5)
+	for i := 0; i < sentences; i++ {
+		words := make([]string, 6+rnd.Intn(10))
+		for j := range words {
+			words[j] = pick(rnd, loremWords)
+		}
+		words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+		if i > 0 {
+			about.WriteString(" ")
+		}
+		about.WriteString(strings.Join(words, " ") + ".")
+	}
+	about.WriteString("\n")
+	return about.String()
+}
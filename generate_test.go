@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+)
+
+func generateUsers(t *testing.T, config DatasetConfig) []UserXml {
+	buffer := &bytes.Buffer{}
+	if err := GenerateDataset(buffer, config); err != nil {
+		t.Fatalf("cant generate dataset: %s", err)
+	}
+	users, err := parseUsers(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("cant parse dataset: %s", err)
+	}
+	return users.List
+}
+
+func TestGenerateDatasetReproducible(t *testing.T) {
+	config := DatasetConfig{Rows: 50, Seed: 7, UnicodeNamesRate: 0.3, EmptyFieldsRate: 0.3, DuplicateNamesRate: 0.3}
+	first, second := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := GenerateDataset(first, config); err != nil {
+		t.Fatalf("cant generate dataset: %s", err)
+	}
+	if err := GenerateDataset(second, config); err != nil {
+		t.Fatalf("cant generate dataset: %s", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("same seed gave different datasets")
+	}
+
+	config.Seed = 8
+	third := &bytes.Buffer{}
+	if err := GenerateDataset(third, config); err != nil {
+		t.Fatalf("cant generate dataset: %s", err)
+	}
+	if bytes.Equal(first.Bytes(), third.Bytes()) {
+		t.Errorf("different seeds gave the same dataset")
+	}
+}
+
+func TestGenerateDatasetDistribution(t *testing.T) {
+	users := generateUsers(t, DatasetConfig{
+		Rows:         2000,
+		Seed:         1,
+		Distribution: &DatasetDistribution{MinAge: 30, MaxAge: 35, FemaleRatio: 0.8, ActiveRatio: 0},
+	})
+	if len(users) != 2000 {
+		t.Fatalf("expected 2000 rows, got %d", len(users))
+	}
+
+	female := 0
+	for i, user := range users {
+		if user.ID != i {
+			t.Errorf("row %d has id %d", i, user.ID)
+		}
+		if user.Age < 30 || user.Age > 35 {
+			t.Errorf("row %d has age %d outside 30..35", i, user.Age)
+		}
+		if user.Active {
+			t.Errorf("row %d is active with zero active ratio", i)
+		}
+		if user.Gender == "female" {
+			female++
+		}
+	}
+	if ratio := float64(female) / float64(len(users)); ratio < 0.75 || ratio > 0.85 {
+		t.Errorf("female ratio %.2f is far from 0.8", ratio)
+	}
+}
+
+func TestGenerateDatasetEdgeCases(t *testing.T) {
+	plain := generateUsers(t, DatasetConfig{Rows: 500, Seed: 1})
+	for _, user := range plain {
+		if user.FirstName == "" || user.LastName == "" || user.Email == "" || user.About == "" {
+			t.Fatalf("row %d has empty fields without edge cases", user.ID)
+		}
+		if !isASCII(user.FirstName + user.LastName) {
+			t.Fatalf("row %d has non-ASCII name without edge cases", user.ID)
+		}
+	}
+
+	users := generateUsers(t, DatasetConfig{Rows: 500, Seed: 1, UnicodeNamesRate: 0.2, EmptyFieldsRate: 0.2, DuplicateNamesRate: 0.2})
+	unicode, empty := 0, 0
+	names := map[string]int{}
+	for _, user := range users {
+		if !isASCII(user.FirstName + user.LastName) {
+			unicode++
+		}
+		if user.GUID == "" || user.FirstName == "" || user.Email == "" || user.About == "" || user.Company == "" ||
+			user.Phone == "" || user.Address == "" || user.Balance == "" || user.EyeColor == "" {
+			empty++
+		}
+		names[user.FirstName+" "+user.LastName]++
+	}
+	duplicates := len(users) - len(names)
+	if unicode == 0 || empty == 0 || duplicates < 50 {
+		t.Errorf("expected edge cases, got unicode=%d empty=%d duplicates=%d", unicode, empty, duplicates)
+	}
+}
+
+func isASCII(s string) bool {
+	return utf8.RuneCountInString(s) == len(s)
+}
+
+func TestDatasetConfigValidate(t *testing.T) {
+	cases := []DatasetConfig{
+		{Rows: -1},
+		{Rows: 1, Distribution: &DatasetDistribution{MinAge: 40, MaxAge: 30}},
+		{Rows: 1, Distribution: &DatasetDistribution{MaxAge: 30, FemaleRatio: 1.5}},
+		{Rows: 1, EmptyFieldsRate: -0.1},
+	}
+	for i, config := range cases {
+		if err := GenerateDataset(ioutil.Discard, config); err == nil {
+			t.Errorf("[%d] expected error for %+v", i, config)
+		}
+	}
+}
+
+func TestGenDatasetCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "dataset.xml")
+	err := genDatasetCommand([]string{"-rows", "20", "-seed", "3", "-min-age", "20", "-max-age", "20", "-unicode-names", "1", "-out", out}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	users, err := loadUsersFrom(out)
+	if err != nil {
+		t.Fatalf("cant load generated dataset: %s", err)
+	}
+	if len(users.List) != 20 {
+		t.Fatalf("expected 20 rows, got %d", len(users.List))
+	}
+	for _, user := range users.List {
+		if user.Age != 20 || isASCII(user.FirstName+user.LastName) {
+			t.Errorf("row %d does not follow flags: %+v", user.ID, user)
+		}
+	}
+
+	stdout := &bytes.Buffer{}
+	if err := genDatasetCommand([]string{"-rows", "2"}, stdout); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Contains(stdout.Bytes(), []byte("<row>")) {
+		t.Errorf("expected dataset on stdout, got %q", stdout.String())
+	}
+
+	if err := genDatasetCommand([]string{"-rows", "2", "-active-ratio", "2"}, ioutil.Discard); err == nil {
+		t.Errorf("expected error for bad ratio")
+	}
+}
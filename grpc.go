@@ -0,0 +1,208 @@
+//go:build grpc
+
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative searchpb/search.proto
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/asannikov/golang-webservices-1-week4/searchpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ключ метаданных, в котором передаётся токен авторизации
+const grpcTokenKey = "accesstoken"
+
+// GRPCSearchServer - сервис UserSearch поверх того же датасета и поиска, что и SearchServer
+type GRPCSearchServer struct {
+	searchpb.UnimplementedUserSearchServer
+}
+
+func grpcParams(ctx context.Context, req *searchpb.SearchRequest) (searchParams, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if tokens := md.Get(grpcTokenKey); len(tokens) == 0 || tokens[0] != serverAccessToken {
+		return searchParams{}, status.Error(codes.Unauthenticated, ErrorCodeBadAccessToken)
+	}
+
+	orderField, err := normalizeOrderField(req.GetOrderField())
+	if err != nil {
+		return searchParams{}, status.Error(codes.InvalidArgument, ErrorCodeBadOrderField)
+	}
+	if req.GetLimit() < 0 || req.GetOffset() < 0 {
+		return searchParams{}, status.Error(codes.InvalidArgument, ErrorCodeBadParam)
+	}
+
+	return searchParams{
+		Query:      req.GetQuery(),
+		OrderField: orderField,
+		Limit:      int(req.GetLimit()),
+		Offset:     int(req.GetOffset()),
+		OrderBy:    int(req.GetOrderBy()),
+	}, nil
+}
+
+func (s *GRPCSearchServer) FindUsers(ctx context.Context, req *searchpb.SearchRequest) (*searchpb.SearchResponse, error) {
+	params, err := grpcParams(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	store, _, err := openServerStore()
+	if err != nil {
+		return nil, status.Error(codes.Internal, ErrorCodeInternal)
+	}
+
+	page, nextPage, err := searchPage(store, params)
+	if err != nil {
+		return nil, status.Error(codes.Internal, ErrorCodeInternal)
+	}
+	resp := &searchpb.SearchResponse{NextPage: nextPage}
+	for _, user := range page {
+		resp.Users = append(resp.Users, toProtoUser(user))
+	}
+	return resp, nil
+}
+
+func (s *GRPCSearchServer) StreamUsers(req *searchpb.SearchRequest, stream searchpb.UserSearch_StreamUsersServer) error {
+	params, err := grpcParams(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+	store, _, err := openServerStore()
+	if err != nil {
+		return status.Error(codes.Internal, ErrorCodeInternal)
+	}
+
+	if params.Limit == 0 {
+		params.Limit = -1
+	}
+	var sendErr error
+	err = eachRow(stream.Context(), store, params.request(), func(row UserXml) error {
+		sendErr = stream.Send(toProtoUser(row.User()))
+		return sendErr
+	})
+	if sendErr != nil || stream.Context().Err() != nil {
+		return err
+	}
+	if err != nil {
+		return status.Error(codes.Internal, ErrorCodeInternal)
+	}
+	return nil
+}
+
+// GRPCSearchClient - аналог SearchClient, который ходит во внешнюю систему по gRPC
+type GRPCSearchClient struct {
+	// токен, уходит в метаданных запроса
+	AccessToken string
+	Conn        grpc.ClientConnInterface
+}
+
+var _ UserSearcher = (*GRPCSearchClient)(nil)
+
+func (srv *GRPCSearchClient) context() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), grpcTokenKey, srv.AccessToken)
+}
+
+// FindUsers отправляет запрос во внешнюю систему по gRPC, семантика как у SearchClient.FindUsers
+func (srv *GRPCSearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	req, err := normalizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(srv.context(), client.Timeout)
+	defer cancel()
+
+	resp, err := searchpb.NewUserSearchClient(srv.Conn).FindUsers(ctx, toProtoRequest(req))
+	if err != nil {
+		return nil, grpcError(err, req)
+	}
+
+	result := SearchResponse{NextPage: resp.GetNextPage()}
+	for _, user := range resp.GetUsers() {
+		result.Users = append(result.Users, fromProtoUser(user))
+	}
+	return &result, nil
+}
+
+// StreamUsers получает все подходящие записи потоком и передаёт их в fn по одной.
+// Limit не учитывается. Ошибка из fn прерывает поток и возвращается как есть
+func (srv *GRPCSearchClient) StreamUsers(req SearchRequest, fn func(User) error) error {
+	if req.Offset < 0 {
+		return fmt.Errorf("offset must be > 0")
+	}
+	req.Limit = 0
+
+	ctx, cancel := context.WithCancel(srv.context())
+	defer cancel()
+
+	stream, err := searchpb.NewUserSearchClient(srv.Conn).StreamUsers(ctx, toProtoRequest(req))
+	if err != nil {
+		return grpcError(err, req)
+	}
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return grpcError(err, req)
+		}
+		if err := fn(fromProtoUser(user)); err != nil {
+			return err
+		}
+	}
+}
+
+func grpcError(err error, req SearchRequest) error {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.Unauthenticated:
+		return fmt.Errorf("Bad AccessToken")
+	case codes.DeadlineExceeded:
+		return fmt.Errorf("timeout for %+v", req)
+	case codes.Internal:
+		return fmt.Errorf("SearchServer fatal error")
+	case codes.InvalidArgument:
+		if st.Message() == ErrorCodeBadOrderField {
+			return fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		}
+		return fmt.Errorf("unknown bad request error: %s", st.Message())
+	}
+	return fmt.Errorf("unknown error %s", err)
+}
+
+func toProtoRequest(req SearchRequest) *searchpb.SearchRequest {
+	return &searchpb.SearchRequest{
+		Limit:      int32(req.Limit),
+		Offset:     int32(req.Offset),
+		Query:      req.Query,
+		OrderField: req.OrderField,
+		OrderBy:    int32(req.OrderBy),
+	}
+}
+
+func toProtoUser(user User) *searchpb.User {
+	return &searchpb.User{
+		Id:     int32(user.Id),
+		Name:   user.Name,
+		Age:    int32(user.Age),
+		About:  user.About,
+		Gender: user.Gender,
+	}
+}
+
+func fromProtoUser(user *searchpb.User) User {
+	return User{
+		Id:     int(user.GetId()),
+		Name:   user.GetName(),
+		Age:    int(user.GetAge()),
+		About:  user.GetAbout(),
+		Gender: user.GetGender(),
+	}
+}
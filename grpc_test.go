@@ -0,0 +1,122 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/asannikov/golang-webservices-1-week4/searchpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startGRPCServer(t *testing.T) (*grpc.ClientConn, func()) {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	searchpb.RegisterUserSearchServer(server, &GRPCSearchServer{})
+	go server.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("cant dial grpc server: %s", err)
+	}
+	return conn, func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestGRPCSearchClient(t *testing.T) {
+	cases := []TestCase{
+		tResultCase1(),
+		tResultCase2(),
+		tResultCase3(),
+		tResultCase4(),
+		tResultCase5(),
+		tResultCase6(),
+		tResultCase7(),
+		tResultCase8(),
+		tResultCase9(),
+		tResultCase10(),
+		tResultCase11(),
+		tResultCase12(),
+		tResultCase13(),
+	}
+
+	conn, stop := startGRPCServer(t)
+	defer stop()
+
+	for caseNum, item := range cases {
+		var s UserSearcher = &GRPCSearchClient{
+			AccessToken: item.Token,
+			Conn:        conn,
+		}
+		result, err := s.FindUsers(*item.Query)
+
+		if err != nil && !item.IsError {
+			t.Errorf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%d] expected error, got nil", caseNum)
+		}
+
+		if !reflect.DeepEqual(item.Result, result) {
+			t.Errorf("[%d] wrong result, expected %#v\n, got \n %#v", caseNum, item.Result, result)
+		}
+	}
+}
+
+func TestGRPCStreamUsers(t *testing.T) {
+	cases := []struct {
+		Token   string
+		Query   SearchRequest
+		Count   int
+		IsError bool
+	}{
+		{serverAccessToken, SearchRequest{}, 35, false},
+		{serverAccessToken, SearchRequest{OrderField: "Id", Offset: 30}, 5, false},
+		{serverAccessToken, SearchRequest{Limit: 3}, 35, false},
+		{serverAccessToken, SearchRequest{Query: "Aguilar"}, 1, false},
+		{serverAccessToken, SearchRequest{OrderField: "picture"}, 0, true},
+		{serverAccessToken, SearchRequest{Offset: -1}, 0, true},
+		{"bad", SearchRequest{}, 0, true},
+	}
+
+	conn, stop := startGRPCServer(t)
+	defer stop()
+
+	for caseNum, item := range cases {
+		s := &GRPCSearchClient{AccessToken: item.Token, Conn: conn}
+		count := 0
+		err := s.StreamUsers(item.Query, func(User) error {
+			count++
+			return nil
+		})
+
+		if err != nil && !item.IsError {
+			t.Errorf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		if err == nil && item.IsError {
+			t.Errorf("[%d] expected error, got nil", caseNum)
+		}
+		if count != item.Count {
+			t.Errorf("[%d] wrong count, expected %d, got %d", caseNum, item.Count, count)
+		}
+	}
+
+	stopErr := errors.New("stop")
+	s := &GRPCSearchClient{AccessToken: serverAccessToken, Conn: conn}
+	if err := s.StreamUsers(SearchRequest{}, func(User) error { return stopErr }); err != stopErr {
+		t.Errorf("expected callback error, got %#v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHedgeDelay = 100 * time.Millisecond
+	defaultHedgeRate  = 0.1
+	defaultHedgeBurst = 3
+)
+
+// HedgePolicy дублирует медленные запросы FindUsers: если ответа нет дольше Delay, тот же запрос
+// отправляется ещё раз (с Balancer - на другую реплику) и берётся ответ, пришедший первым без сбоя,
+// а второй запрос отменяется. Дубли ограничивает корзина токенов: каждый запрос добавляет в неё MaxRate,
+// дубль забирает один, и больше Burst в ней не копится. Поэтому первые запросы тоже можно дублировать,
+// а после долгого затишья дублей подряд не больше Burst.
+// Нулевое значение готово к работе, одну HedgePolicy можно делить между клиентами
+type HedgePolicy struct {
+	// сколько ждать ответа до отправки дубля; 0 - defaultHedgeDelay
+	Delay time.Duration
+	// доля запросов, которые можно продублировать; 0 - defaultHedgeRate, 1 - без ограничения
+	MaxRate float64
+	// сколько дублей можно отправить подряд; 0 - defaultHedgeBurst
+	Burst int
+
+	mu sync.Mutex
+	// сколько токенов не хватает до полной корзины, у нулевого значения корзина полная
+	spent float64
+	stats HedgeStats
+}
+
+// HedgeStats - счётчики HedgePolicy
+type HedgeStats struct {
+	// запросы, прошедшие через политику
+	Requests int
+	// отправленные дубли
+	Hedged int
+	// ответы, которые первым вернул дубль
+	Won int
+	// дубли, не отправленные из-за MaxRate и Burst
+	Capped int
+}
+
+// Stats возвращает счётчики
+func (h *HedgePolicy) Stats() HedgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+func (h *HedgePolicy) delay() time.Duration {
+	if h.Delay <= 0 {
+		return defaultHedgeDelay
+	}
+	return h.Delay
+}
+
+// request учитывает запрос и добавляет в корзину MaxRate токенов
+func (h *HedgePolicy) request() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rate := h.MaxRate
+	if rate <= 0 {
+		rate = defaultHedgeRate
+	}
+	h.stats.Requests++
+	h.spent -= rate
+	if h.spent < 0 {
+		h.spent = 0
+	}
+}
+
+// allowHedge забирает из корзины токен на дубль, если он там есть
+func (h *HedgePolicy) allowHedge() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	burst := h.Burst
+	if burst <= 0 {
+		burst = defaultHedgeBurst
+	}
+	// допуск на ошибку округления: десять запросов по 0.1 должны дать ровно один токен
+	if h.spent+1 > float64(burst)+1e-9 {
+		h.stats.Capped++
+		return false
+	}
+	h.spent++
+	h.stats.Hedged++
+	return true
+}
+
+type hedgeResult struct {
+	resp    *http.Response
+	err     error
+	attempt int
+}
+
+func (r hedgeResult) failed() bool {
+	return r.err != nil || r.resp.StatusCode >= http.StatusInternalServerError
+}
+
+// do отправляет запрос через send и, если ответ задерживается дольше Delay, его дубль.
+// У каждой попытки свой контекст: проигравшая отменяется сразу, а победившая - при закрытии тела ответа.
+// Если сбоят обе, возвращается результат последней
+func (h *HedgePolicy) do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	h.request()
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	start := func() error {
+		ctx, cancel := context.WithCancel(req.Context())
+		attemptReq := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return err
+			}
+			attemptReq.Body = body
+		}
+		cancels = append(cancels, cancel)
+		attempt := len(cancels) - 1
+		go func() {
+			resp, err := send(attemptReq)
+			results <- hedgeResult{resp: resp, err: err, attempt: attempt}
+		}()
+		return nil
+	}
+	if err := start(); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			if h.allowHedge() && start() == nil {
+				pending++
+			}
+		case result := <-results:
+			pending--
+			if result.failed() && pending > 0 {
+				// ждём вторую попытку
+				if result.resp != nil {
+					result.resp.Body.Close()
+				}
+				cancels[result.attempt]()
+				continue
+			}
+			if result.attempt > 0 && !result.failed() {
+				h.mu.Lock()
+				h.stats.Won++
+				h.mu.Unlock()
+			}
+			for i, cancel := range cancels {
+				if i != result.attempt {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go discardResponse(results)
+			}
+			if result.resp == nil {
+				cancels[result.attempt]()
+				return nil, result.err
+			}
+			result.resp.Body = &cancelBody{ReadCloser: result.resp.Body, cancel: cancels[result.attempt]}
+			return result.resp, nil
+		}
+	}
+}
+
+// hedgedRoundTrip отправляет запрос через roundTrip, при заданной hedge - с дублем медленного запроса
+func (srv *SearchClient) hedgedRoundTrip(httpClient *http.Client, req *http.Request, hedge *HedgePolicy) (*http.Response, error) {
+	if hedge == nil {
+		return srv.roundTrip(httpClient, req)
+	}
+	return hedge.do(req, func(req *http.Request) (*http.Response, error) {
+		return srv.roundTrip(httpClient, req)
+	})
+}
+
+// discardResponse закрывает ответ отменённой попытки, если он всё-таки пришёл
+func discardResponse(results chan hedgeResult) {
+	if result := <-results; result.resp != nil {
+		result.resp.Body.Close()
+	}
+}
+
+// cancelBody отменяет контекст запроса, когда тело ответа закрыто
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSearchClientHedge(t *testing.T) {
+	slow, fast := newGatedHandler(), &flakyServer{}
+	defer close(slow.gate)
+	urls := newReplicas(t, slow, fast)
+	balancer := &LoadBalancer{URLs: urls}
+	hedge := &HedgePolicy{Delay: 200 * time.Millisecond, MaxRate: 1}
+	client := &SearchClient{AccessToken: serverAccessToken, Balancer: balancer, Hedge: hedge}
+
+	start := time.Now()
+	result, err := client.FindUsers(SearchRequest{Limit: 1})
+	if err != nil || len(result.Users) != 1 {
+		t.Fatalf("unexpected result %v %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("hedge must not wait for the slow replica, took %s", elapsed)
+	}
+	if stats := hedge.Stats(); stats.Requests != 1 || stats.Hedged != 1 || stats.Won != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	// проигравший запрос отменяется, и реплика за это не наказывается
+	select {
+	case <-slow.canceled:
+	case <-time.After(time.Second):
+		t.Errorf("expected the slow request to be canceled")
+	}
+	waitFor(t, "slow replica release", func() bool { return balancer.Endpoints()[0].Outstanding == 0 })
+	if stats := balancer.Endpoints(); stats[0].Errors != 0 {
+		t.Errorf("canceled hedge loser counted as failure: %+v", stats[0])
+	}
+
+	// быстрый ответ не дублируется
+	direct := &SearchClient{AccessToken: serverAccessToken, URL: urls[1], Hedge: hedge}
+	if _, err := direct.FindUsers(SearchRequest{Limit: 1}); err != nil || hedge.Stats().Hedged != 1 {
+		t.Errorf("fast response must not be hedged, got %v %+v", err, hedge.Stats())
+	}
+}
+
+func TestSearchClientHedgeRate(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		SearchServer(w, r)
+	})
+	urls := newReplicas(t, slow)
+	hedge := &HedgePolicy{Delay: 5 * time.Millisecond, MaxRate: 0.5, Burst: 1}
+	client := &SearchClient{AccessToken: serverAccessToken, URL: urls[0], Hedge: hedge}
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if stats := hedge.Stats(); stats.Requests != 4 || stats.Hedged != 2 || stats.Capped != 2 {
+		t.Errorf("expected every second request hedged, got %+v", stats)
+	}
+}
+
+func TestHedgePolicyColdStart(t *testing.T) {
+	// с лимитом за всё время первые десять запросов при доле 0.1 дублировать было нельзя
+	hedge := &HedgePolicy{}
+	for i := 0; i < defaultHedgeBurst; i++ {
+		hedge.request()
+		if !hedge.allowHedge() {
+			t.Fatalf("request %d: expected hedge from a full bucket, got %+v", i, hedge.Stats())
+		}
+	}
+	hedge.request()
+	if hedge.allowHedge() {
+		t.Errorf("expected empty bucket after %d hedges, got %+v", defaultHedgeBurst, hedge.Stats())
+	}
+	// токен копится за 1/MaxRate запросов
+	for i := 0; i < 9; i++ {
+		hedge.request()
+	}
+	if !hedge.allowHedge() {
+		t.Errorf("expected a token after 10 requests, got %+v", hedge.Stats())
+	}
+}
+
+func TestHedgePolicyBurst(t *testing.T) {
+	// после долгого затишья лимит за всё время пропустил бы сотню дублей подряд
+	hedge := &HedgePolicy{MaxRate: 0.1, Burst: 2}
+	for i := 0; i < 1000; i++ {
+		hedge.request()
+	}
+	for i := 0; i < 20; i++ {
+		hedge.request()
+		hedge.allowHedge()
+	}
+	// 2 из полной корзины и ещё один, когда за 10 запросов накопился токен
+	if stats := hedge.Stats(); stats.Hedged != 3 || stats.Capped != 17 {
+		t.Errorf("expected 3 hedges in a burst of 20, got %+v", stats)
+	}
+}
+
+func TestSearchClientHedgeBothFail(t *testing.T) {
+	down := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	urls := newReplicas(t, down)
+	hedge := &HedgePolicy{Delay: 5 * time.Millisecond, MaxRate: 1}
+	client := &SearchClient{AccessToken: serverAccessToken, URL: urls[0], Hedge: hedge}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("expected server error, got %v", err)
+	}
+	if stats := hedge.Stats(); stats.Hedged != 1 || stats.Won != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadOptions - параметры потоковой загрузки датасета
+type LoadOptions struct {
+	// Progress вызывается каждые ProgressEvery строк и один раз в конце загрузки
+	Progress      func(LoadProgress)
+	ProgressEvery int
+	// RowError получает битые строки, по умолчанию они пишутся в лог
+	RowError func(RowError)
+	// Index строит индексы сортировки для NewIndexedStore по ходу чтения, а не отдельным проходом после загрузки
+	Index bool
+}
+
+// LoadProgress - состояние загрузки датасета
+type LoadProgress struct {
+	Rows    int
+	Skipped int
+	// сколько байт входа уже прочитано
+	Bytes int64
+	Done  bool
+}
+
+// RowError - строка датасета, которую не удалось разобрать
+type RowError struct {
+	// порядковый номер записи в датасете, с 1
+	Row int
+	// строка файла, на которой начинается запись, 0 - если формат её не сообщает
+	Line  int
+	Field string
+	Err   error
+}
+
+func (e RowError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("row %d: %s: %s", e.Row, e.Field, e.Err)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Err)
+}
+
+const defaultProgressEvery = 10000
+
+// rawRow - строка датасета до разбора чисел, чтобы ошибка в одном поле не ломала всю загрузку
+type rawRow struct {
+	ID     string `xml:"id"`
+	Active string `xml:"isActive"`
+	Age    string `xml:"age"`
+	UserXml
+}
+
+// LoadUsers читает датасет в формате dataset.xml по одной строке <row>, не загружая файл в память целиком.
+// Строки с неразбираемыми значениями пропускаются, синтаксическая ошибка XML прерывает загрузку -
+// тогда возвращаются уже прочитанные строки вместе с ошибкой
+func LoadUsers(r io.Reader, opts LoadOptions) (Users, LoadProgress, error) {
+	loader := newRowLoader(opts)
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return loader.fail(decoder.InputOffset(), err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		line, _ := decoder.InputPos()
+		raw := rawRow{}
+		if err := decoder.DecodeElement(&raw, &start); err != nil {
+			return loader.fail(decoder.InputOffset(), err)
+		}
+		row, rowErr := raw.userXml()
+		loader.add(row, rowErr, line, decoder.InputOffset())
+	}
+	return loader.done(decoder.InputOffset())
+}
+
+// rowLoader - общая часть потоковых загрузчиков: копит строки, считает прогресс и сообщает о битых строках
+type rowLoader struct {
+	opts     LoadOptions
+	users    Users
+	progress LoadProgress
+	// nil - индексы не нужны
+	index *indexBuilder
+}
+
+// logRowError - обработчик битых строк по умолчанию
+func logRowError(err RowError) {
+	log.Printf("dataset: skip row at %s", err)
+}
+
+func newRowLoader(opts LoadOptions) *rowLoader {
+	if opts.ProgressEvery <= 0 {
+		opts.ProgressEvery = defaultProgressEvery
+	}
+	if opts.RowError == nil {
+		opts.RowError = logRowError
+	}
+	loader := &rowLoader{opts: opts, users: Users{List: []UserXml{}}}
+	if opts.Index {
+		loader.index = newIndexBuilder()
+	}
+	return loader
+}
+
+// add учитывает очередную запись; line - строка файла, где она начинается, offset - прочитано байт
+func (l *rowLoader) add(row UserXml, rowErr *RowError, line int, offset int64) {
+	if rowErr != nil {
+		rowErr.Row, rowErr.Line = l.progress.Rows+l.progress.Skipped+1, line
+		l.opts.RowError(*rowErr)
+		l.progress.Skipped++
+	} else {
+		l.users.List = append(l.users.List, row)
+		if l.index != nil {
+			l.index.add(row)
+		}
+		l.progress.Rows++
+	}
+
+	if (l.progress.Rows+l.progress.Skipped)%l.opts.ProgressEvery == 0 && l.opts.Progress != nil {
+		l.progress.Bytes = offset
+		l.opts.Progress(l.progress)
+	}
+}
+
+func (l *rowLoader) fail(offset int64, err error) (Users, LoadProgress, error) {
+	l.progress.Bytes = offset
+	return l.users, l.progress, err
+}
+
+func (l *rowLoader) done(offset int64) (Users, LoadProgress, error) {
+	l.progress.Bytes = offset
+	l.progress.Done = true
+	if l.index != nil {
+		l.users.index = l.index.build()
+	}
+	if l.opts.Progress != nil {
+		l.opts.Progress(l.progress)
+	}
+	return l.users, l.progress, nil
+}
+
+// userXml разбирает числовые и логические поля так же, как xml.Unmarshal: пустое значение - ноль
+func (raw rawRow) userXml() (UserXml, *RowError) {
+	row := raw.UserXml
+	var err error
+	if row.ID, err = parseXMLInt(raw.ID); err != nil {
+		return row, &RowError{Field: "id", Err: err}
+	}
+	if row.Age, err = parseXMLInt(raw.Age); err != nil {
+		return row, &RowError{Field: "age", Err: err}
+	}
+	if value := strings.TrimSpace(raw.Active); value != "" {
+		if row.Active, err = strconv.ParseBool(value); err != nil {
+			return row, &RowError{Field: "isActive", Err: err}
+		}
+	}
+	return row, nil
+}
+
+func parseXMLInt(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// sortIndex - позиции записей в Users.List по возрастанию и по убыванию каждого поля сортировки.
+// При равных значениях поля записи идут в порядке датасета
+type sortIndex struct {
+	asc, desc map[string][]int
+}
+
+// indexBuilder копит позиции записей по значениям полей сортировки, пока строки читаются.
+// В конце сортируются только различные значения, а не все записи: у age их десятки на любой датасет
+type indexBuilder struct {
+	rows   int
+	byID   map[int][]int
+	byAge  map[int][]int
+	byName map[string][]int
+}
+
+func newIndexBuilder() *indexBuilder {
+	return &indexBuilder{byID: map[int][]int{}, byAge: map[int][]int{}, byName: map[string][]int{}}
+}
+
+// add учитывает очередную запись датасета
+func (b *indexBuilder) add(row UserXml) {
+	b.byID[row.ID] = append(b.byID[row.ID], b.rows)
+	b.byAge[row.Age] = append(b.byAge[row.Age], b.rows)
+	name := row.FirstName + " " + row.LastName
+	b.byName[name] = append(b.byName[name], b.rows)
+	b.rows++
+}
+
+func (b *indexBuilder) build() *sortIndex {
+	index := &sortIndex{asc: map[string][]int{}, desc: map[string][]int{}}
+	index.asc["id"], index.desc["id"] = concatGroups(intGroups(b.byID), b.rows)
+	index.asc["age"], index.desc["age"] = concatGroups(intGroups(b.byAge), b.rows)
+	index.asc["name"], index.desc["name"] = concatGroups(stringGroups(b.byName), b.rows)
+	return index
+}
+
+// intGroups возвращает группы позиций по возрастанию значения
+func intGroups(groups map[int][]int) [][]int {
+	keys := make([]int, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	result := make([][]int, len(keys))
+	for i, key := range keys {
+		result[i] = groups[key]
+	}
+	return result
+}
+
+// stringGroups возвращает группы позиций по возрастанию значения
+func stringGroups(groups map[string][]int) [][]int {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([][]int, len(keys))
+	for i, key := range keys {
+		result[i] = groups[key]
+	}
+	return result
+}
+
+// concatGroups склеивает группы в порядок по возрастанию и по убыванию; внутри группы порядок датасета сохраняется в обоих
+func concatGroups(groups [][]int, rows int) (asc, desc []int) {
+	asc, desc = make([]int, 0, rows), make([]int, 0, rows)
+	for _, group := range groups {
+		asc = append(asc, group...)
+	}
+	for i := len(groups) - 1; i >= 0; i-- {
+		desc = append(desc, groups[i]...)
+	}
+	return asc, desc
+}
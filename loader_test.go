@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadUsersMatchesUnmarshal(t *testing.T) {
+	data, err := ioutil.ReadFile("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant read dataset: %s", err)
+	}
+	expected := Users{}
+	if err := xml.Unmarshal(data, &expected); err != nil {
+		t.Fatalf("cant unmarshal dataset: %s", err)
+	}
+
+	users, progress, err := LoadUsers(strings.NewReader(string(data)), LoadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(users.List, expected.List) {
+		t.Errorf("streaming loader differs from xml.Unmarshal")
+	}
+	if !progress.Done || progress.Rows != len(expected.List) || progress.Skipped != 0 || progress.Bytes != int64(len(data)) {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+}
+
+const malformedDataset = `<?xml version="1.0" encoding="UTF-8" ?>
+<root>
+  <row>
+    <id>0</id>
+    <age>20</age>
+    <first_name>Boyd</first_name>
+  </row>
+  <row>
+    <id>1</id>
+    <age>twenty</age>
+    <first_name>Hilda</first_name>
+  </row>
+  <row>
+    <id>2</id>
+    <isActive>maybe</isActive>
+  </row>
+  <row>
+    <id></id>
+    <first_name>Empty</first_name>
+  </row>
+</root>
+`
+
+func TestLoadUsersSkipsMalformedRows(t *testing.T) {
+	var rowErrors []RowError
+	users, progress, err := LoadUsers(strings.NewReader(malformedDataset), LoadOptions{
+		RowError: func(err RowError) { rowErrors = append(rowErrors, err) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(users.List) != 2 || users.List[0].FirstName != "Boyd" || users.List[1].FirstName != "Empty" {
+		t.Errorf("unexpected rows %+v", users.List)
+	}
+	if progress.Rows != 2 || progress.Skipped != 2 {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+
+	if len(rowErrors) != 2 {
+		t.Fatalf("expected 2 row errors, got %v", rowErrors)
+	}
+	if rowErrors[0].Line != 8 || rowErrors[0].Field != "age" {
+		t.Errorf("unexpected first error %s", rowErrors[0])
+	}
+	if rowErrors[1].Line != 13 || rowErrors[1].Field != "isActive" {
+		t.Errorf("unexpected second error %s", rowErrors[1])
+	}
+	if !strings.HasPrefix(rowErrors[0].Error(), "line 8: age: ") {
+		t.Errorf("unexpected message %q", rowErrors[0].Error())
+	}
+}
+
+func TestLoadUsersProgress(t *testing.T) {
+	var reports []LoadProgress
+	_, _, err := LoadUsers(strings.NewReader(malformedDataset), LoadOptions{
+		ProgressEvery: 3,
+		RowError:      func(RowError) {},
+		Progress:      func(progress LoadProgress) { reports = append(reports, progress) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reports, got %+v", reports)
+	}
+	if reports[0].Rows+reports[0].Skipped != 3 || reports[0].Done || reports[0].Bytes == 0 {
+		t.Errorf("unexpected intermediate report %+v", reports[0])
+	}
+	if !reports[1].Done || reports[1].Rows != 2 || reports[1].Skipped != 2 {
+		t.Errorf("unexpected final report %+v", reports[1])
+	}
+}
+
+func TestLoadUsersSyntaxError(t *testing.T) {
+	broken := `<root><row><id>1</id></row><row><id>2</id></row><row><id>3</ro`
+	users, progress, err := LoadUsers(strings.NewReader(broken), LoadOptions{})
+	if err == nil {
+		t.Fatalf("expected syntax error")
+	}
+	if len(users.List) != 2 || progress.Rows != 2 || progress.Done {
+		t.Errorf("expected rows loaded before the error, got %+v %+v", users.List, progress)
+	}
+}
+
+func TestLoadUsersBuildsIndex(t *testing.T) {
+	file, err := os.Open("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant open dataset: %s", err)
+	}
+	defer file.Close()
+	users, _, err := LoadUsers(file, LoadOptions{Index: true})
+	if err != nil || users.index == nil {
+		t.Fatalf("expected index built while loading, got %v", err)
+	}
+
+	keys := map[string]func(a, b UserXml) int{
+		"id":  func(a, b UserXml) int { return a.ID - b.ID },
+		"age": func(a, b UserXml) int { return a.Age - b.Age },
+		"name": func(a, b UserXml) int {
+			return strings.Compare(a.FirstName+" "+a.LastName, b.FirstName+" "+b.LastName)
+		},
+	}
+	for field, compare := range keys {
+		// тот же порядок, что даёт устойчивая сортировка всего датасета
+		asc, desc := make([]int, len(users.List)), make([]int, len(users.List))
+		for i := range asc {
+			asc[i], desc[i] = i, i
+		}
+		sort.SliceStable(asc, func(i, j int) bool { return compare(users.List[asc[i]], users.List[asc[j]]) < 0 })
+		sort.SliceStable(desc, func(i, j int) bool { return compare(users.List[desc[i]], users.List[desc[j]]) > 0 })
+		if !reflect.DeepEqual(users.index.asc[field], asc) || !reflect.DeepEqual(users.index.desc[field], desc) {
+			t.Errorf("[%s] index differs from stable sort", field)
+		}
+	}
+
+	// сортировка на месте делает индекс недействительным
+	users.FindRows("", "age", 1, 0, OrderByAsc)
+	if users.index != nil {
+		t.Errorf("expected index dropped after in-place sort")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const usage = `usage: search <command> [flags]
+
+commands:
+  gen-dataset       сгенерировать синтетический датасет в формате dataset.xml
+  validate-dataset  проверить датасет по схеме и вывести отчёт в JSON
+  convert-dataset   перенести датасет из XML в JSON, CSV или базу SQLite
+  serve             запустить SearchServer
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "gen-dataset":
+		err = genDatasetCommand(os.Args[2:], os.Stdout)
+	case "validate-dataset":
+		err = validateDatasetCommand(os.Args[2:], os.Stdout)
+	case "convert-dataset":
+		err = convertDatasetCommand(os.Args[2:])
+	case "serve":
+		err = serveCommand(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// genDatasetCommand пишет синтетический датасет в stdout или в файл из -out
+func genDatasetCommand(args []string, stdout io.Writer) error {
+	config := DatasetConfig{Distribution: &DatasetDistribution{}}
+	flags := flag.NewFlagSet("gen-dataset", flag.ContinueOnError)
+	flags.IntVar(&config.Rows, "rows", 1000, "количество строк")
+	flags.Int64Var(&config.Seed, "seed", 1, "seed генератора, одинаковый seed даёт одинаковый датасет")
+	flags.IntVar(&config.Distribution.MinAge, "min-age", DefaultDistribution.MinAge, "минимальный возраст")
+	flags.IntVar(&config.Distribution.MaxAge, "max-age", DefaultDistribution.MaxAge, "максимальный возраст")
+	flags.Float64Var(&config.Distribution.FemaleRatio, "female-ratio", DefaultDistribution.FemaleRatio, "доля женщин")
+	flags.Float64Var(&config.Distribution.ActiveRatio, "active-ratio", DefaultDistribution.ActiveRatio, "доля активных пользователей")
+	flags.Float64Var(&config.UnicodeNamesRate, "unicode-names", 0, "доля строк с не-ASCII именами")
+	flags.Float64Var(&config.EmptyFieldsRate, "empty-fields", 0, "доля строк с пустыми полями")
+	flags.Float64Var(&config.DuplicateNamesRate, "duplicate-names", 0, "доля строк, повторяющих имя одной из предыдущих")
+	out := flags.String("out", "", "файл для записи, по умолчанию stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return GenerateDataset(stdout, config)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := GenerateDataset(file, config); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// validateDatasetCommand печатает отчёт о качестве датасета и возвращает ошибку, если в нём есть замечания
+func validateDatasetCommand(args []string, stdout io.Writer) error {
+	schema := DefaultSchema
+	flags := flag.NewFlagSet("validate-dataset", flag.ContinueOnError)
+	path := flags.String("dataset", datasetPath, "файл датасета")
+	flags.IntVar(&schema.MinAge, "min-age", schema.MinAge, "минимальный допустимый возраст")
+	flags.IntVar(&schema.MaxAge, "max-age", schema.MaxAge, "максимальный допустимый возраст")
+	flags.IntVar(&schema.MaxIssues, "max-issues", 1000, "сколько замечаний выводить, 0 - все")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := validateDatasetFile(*path, schema)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if !report.Valid() {
+		return fmt.Errorf("dataset %s is invalid: %s", *path, report.Summary())
+	}
+	return nil
+}
+
+// режимы проверки датасета при старте сервера
+const (
+	ValidationOff     = "off"
+	ValidationLenient = "lenient"
+	ValidationStrict  = "strict"
+)
+
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", ":8080", "адрес, на котором слушает сервер")
+	kind := flags.String("store", StoreXML, "хранилище: xml, json, csv или sqlite")
+	path := flags.String("dataset", datasetPath, "файл датасета или базы SQLite")
+	mode := flags.String("validate", ValidationLenient, "проверка XML-датасета при старте: strict - не стартовать с ошибками, lenient - только записать в лог, off")
+	tenants := flags.String("tenants", "", "JSON-файл со списком арендаторов; если задан, -store и -dataset не используются")
+	compressMinSize := flags.Int("compress-min-size", defaultCompressMinSize, "ответы короче стольких байт не сжимаются, -1 - не сжимать ответы")
+	traceLog := flags.String("trace-log", "", "файл, куда пишутся спаны трассировки, по JSON-объекту на строку; - - stderr, пусто - без трассировки")
+	metrics := flags.Bool("metrics", true, "отдавать метрики Prometheus на /metrics")
+	maxAge := flags.Duration("cache-max-age", 0, "сколько клиенты могут не перепроверять ответ (Cache-Control: max-age), 0 - перепроверять каждый раз")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *maxAge < 0 {
+		return fmt.Errorf("cache-max-age must not be negative, got %s", *maxAge)
+	}
+	cacheMaxAge = *maxAge
+
+	if *tenants != "" {
+		server, err := loadTenantServer(*tenants)
+		if err != nil {
+			return err
+		}
+		reloadOnSignal(server)
+		handler, err := traced(compressed(measured(server, *metrics, server.Datasets), *compressMinSize), *traceLog)
+		if err != nil {
+			return err
+		}
+		log.Printf("listening on %s with tenants %s", *addr, strings.Join(server.Tenants(), ", "))
+		return http.ListenAndServe(*addr, handler)
+	}
+
+	serverStore = StoreConfig{Kind: *kind}
+	if *kind == StoreXML {
+		datasetPath = *path
+		if err := checkDatasetOnStartup(datasetPath, *mode); err != nil {
+			return err
+		}
+	} else {
+		serverStore.Path = *path
+	}
+	// датасет загружается до первого запроса
+	if _, _, err := openServerStore(); err != nil {
+		return err
+	}
+	handler, err := traced(compressed(measured(http.HandlerFunc(SearchServer), *metrics, serverDatasets), *compressMinSize), *traceLog)
+	if err != nil {
+		return err
+	}
+	log.Printf("listening on %s", *addr)
+	return http.ListenAndServe(*addr, handler)
+}
+
+// compressed включает сжатие ответов, если оно не выключено флагом -compress-min-size -1
+func compressed(handler http.Handler, minSize int) http.Handler {
+	if minSize < 0 {
+		return handler
+	}
+	return CompressHandler(handler, minSize)
+}
+
+// measured включает метрики на /metrics, если они не выключены флагом -metrics=false
+func measured(handler http.Handler, enabled bool, datasets func() []DatasetStats) http.Handler {
+	if !enabled {
+		return handler
+	}
+	return MetricsHandler(handler, &ServerMetrics{Datasets: datasets})
+}
+
+// traced включает трассировку запросов, если задан флаг -trace-log
+func traced(handler http.Handler, path string) (http.Handler, error) {
+	if path == "" {
+		return handler, nil
+	}
+	if traceHandler == nil {
+		return nil, errors.New("tracing is not compiled in, build with -tags otel")
+	}
+	var out io.Writer = os.Stderr
+	if path != "-" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+	if err := exportSpans(out); err != nil {
+		return nil, err
+	}
+	return traceHandler(handler), nil
+}
+
+// checkDatasetOnStartup проверяет датасет по DefaultSchema. В строгом режиме замечания не дают серверу стартовать
+func checkDatasetOnStartup(path, mode string) error {
+	switch mode {
+	case ValidationOff:
+		return nil
+	case ValidationLenient, ValidationStrict:
+	default:
+		return fmt.Errorf("unknown validation mode %s", mode)
+	}
+
+	report, err := validateDatasetFile(path, DefaultSchema)
+	if err != nil {
+		return err
+	}
+	if report.Valid() {
+		log.Printf("dataset %s: %s", path, report.Summary())
+		return nil
+	}
+	if mode == ValidationStrict {
+		return fmt.Errorf("dataset %s is invalid: %s", path, report.Summary())
+	}
+	log.Printf("dataset %s has problems, serving anyway: %s", path, report.Summary())
+	return nil
+}
+
+// convertDatasetCommand переносит XML-датасет в другое хранилище
+func convertDatasetCommand(args []string) error {
+	flags := flag.NewFlagSet("convert-dataset", flag.ContinueOnError)
+	in := flags.String("in", datasetPath, "исходный датасет в формате dataset.xml")
+	kind := flags.String("to", StoreJSON, "формат результата: json, csv или sqlite")
+	out := flags.String("out", "", "файл результата")
+	table := flags.String("table", defaultSQLTable, "таблица для sqlite")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	users, err := loadUsersFrom(*in)
+	if err != nil {
+		return err
+	}
+
+	switch *kind {
+	case StoreSQLite:
+		db, err := openSQLite(*out)
+		if err != nil {
+			return err
+		}
+		return WriteSQL(db, *table, users.List)
+	case StoreJSON, StoreCSV:
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		write := WriteUsersJSON
+		if *kind == StoreCSV {
+			write = WriteUsersCSV
+		}
+		if err := write(file, users.List); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	return fmt.Errorf("unknown store %s", *kind)
+}
+
+// loadTenantServer читает список арендаторов из JSON-файла
+func loadTenantServer(path string) (*TenantServer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("cant parse %s: %s", path, err)
+	}
+	return NewTenantServer(tenants)
+}
+
+// reloadOnSignal перечитывает датасеты арендаторов по SIGHUP; ошибка одного не мешает остальным
+func reloadOnSignal(server *TenantServer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			errs := server.ReloadAll()
+			for _, name := range server.Tenants() {
+				if err, ok := errs[name]; ok {
+					log.Printf("tenant %s: reload failed, keeping old dataset: %s", name, err)
+				} else {
+					log.Printf("tenant %s: reloaded", name)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// путь, на котором MetricsHandler отдаёт метрики
+	metricsPath = "/metrics"
+
+	// сколько байт тела ответа с ошибкой читается, чтобы узнать код ошибки
+	maxErrorBodyForMetrics = 4096
+)
+
+// корзины гистограммы задержки по умолчанию, в секундах
+var defaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// DatasetStats - размер датасета и время его загрузки для метрик
+type DatasetStats struct {
+	Name string
+	Rows int
+	// время последней успешной загрузки; пустое - неизвестно
+	LoadedAt time.Time
+}
+
+// ServerMetrics считает запросы к серверу поиска и отдаёт их в текстовом формате Prometheus.
+// Нулевое значение готово к работе
+type ServerMetrics struct {
+	// датасеты для search_dataset_*, опрашиваются при каждом сборе метрик; nil - без них
+	Datasets func() []DatasetStats
+	// верхние границы корзин гистограммы задержки в секундах по возрастанию; nil - defaultLatencyBuckets
+	Buckets []float64
+
+	once     sync.Once
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	latency  prometheus.Histogram
+}
+
+// init регистрирует метрики при первом использовании, Buckets после этого не меняются
+func (m *ServerMetrics) init() {
+	m.once.Do(func() {
+		buckets := m.Buckets
+		if buckets == nil {
+			buckets = defaultLatencyBuckets
+		}
+		m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "search_requests_total",
+			Help: "Запросы к серверу поиска по коду ответа.",
+		}, []string{"code"})
+		m.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "search_errors_total",
+			Help: "Ответы с ошибкой по коду ответа и коду ошибки из тела.",
+		}, []string{"status", "code"})
+		m.latency = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "search_request_duration_seconds",
+			Help:    "Время обработки запроса.",
+			Buckets: buckets,
+		})
+		m.registry = prometheus.NewRegistry()
+		m.registry.MustRegister(m.requests, m.errors, m.latency)
+		if m.Datasets != nil {
+			m.registry.MustRegister(datasetCollector{datasets: m.Datasets})
+		}
+	})
+}
+
+// observe учитывает законченный запрос; code - код ошибки из тела ответа, если он есть
+func (m *ServerMetrics) observe(status int, code string, duration time.Duration) {
+	m.init()
+	m.requests.WithLabelValues(strconv.Itoa(status)).Inc()
+	if status >= http.StatusBadRequest {
+		m.errors.WithLabelValues(strconv.Itoa(status), code).Inc()
+	}
+	m.latency.Observe(duration.Seconds())
+}
+
+// ServeHTTP отдаёт метрики в текстовом формате Prometheus
+func (m *ServerMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.init()
+	promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+var (
+	datasetRowsDesc   = prometheus.NewDesc("search_dataset_rows", "Записей в датасете.", []string{"dataset"}, nil)
+	datasetLoadedDesc = prometheus.NewDesc("search_dataset_loaded_timestamp_seconds",
+		"Время последней загрузки датасета, unix-время.", []string{"dataset"}, nil)
+)
+
+// datasetCollector опрашивает датасеты при каждом сборе метрик: их набор меняется вместе с арендаторами,
+// поэтому метрики строятся на месте, а не хранятся в GaugeVec
+type datasetCollector struct {
+	datasets func() []DatasetStats
+}
+
+func (c datasetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- datasetRowsDesc
+	ch <- datasetLoadedDesc
+}
+
+func (c datasetCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, dataset := range c.datasets() {
+		ch <- prometheus.MustNewConstMetric(datasetRowsDesc, prometheus.GaugeValue, float64(dataset.Rows), dataset.Name)
+		if !dataset.LoadedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(datasetLoadedDesc, prometheus.GaugeValue,
+				float64(dataset.LoadedAt.UnixNano())/1e9, dataset.Name)
+		}
+	}
+}
+
+// MetricsHandler считает запросы к next в metrics и отдаёт сами метрики на GET /metrics
+func MetricsHandler(next http.Handler, metrics *ServerMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == metricsPath && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			metrics.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		counted := &countedResponse{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(counted, r)
+		code := ""
+		if counted.status >= http.StatusBadRequest {
+			code = searchErrorCode(counted.body.Bytes())
+		}
+		metrics.observe(counted.status, code, time.Since(start))
+	})
+}
+
+// searchErrorCode достаёт код ошибки из тела ответа любой версии API
+func searchErrorCode(body []byte) string {
+	v2 := SearchErrorResponseV2{}
+	if json.Unmarshal(body, &v2) == nil && v2.Error.Code != "" {
+		return v2.Error.Code
+	}
+	v1 := SearchErrorResponse{}
+	if json.Unmarshal(body, &v1) == nil && v1.Error != "" {
+		return v1.Error
+	}
+	legacy := struct {
+		Err string `json:"err"`
+	}{}
+	if json.Unmarshal(body, &legacy) == nil && legacy.Err != "" {
+		return legacy.Err
+	}
+	return "unknown"
+}
+
+// countedResponse запоминает код ответа и начало тела ответа с ошибкой
+type countedResponse struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *countedResponse) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.status, c.wroteHeader = status, true
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countedResponse) Write(data []byte) (int, error) {
+	c.wroteHeader = true
+	if c.status >= http.StatusBadRequest && c.body.Len() < maxErrorBodyForMetrics {
+		rest := data
+		if len(rest) > maxErrorBodyForMetrics-c.body.Len() {
+			rest = rest[:maxErrorBodyForMetrics-c.body.Len()]
+		}
+		c.body.Write(rest)
+	}
+	return c.ResponseWriter.Write(data)
+}
+
+func (c *countedResponse) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// ClientMetrics получает события SearchClient для метрик. Методы вызываются из разных горутин
+type ClientMetrics interface {
+	// отправлен HTTP-запрос к серверу, в том числе повторный
+	Attempt()
+	// запрос FindUsers отправлен ещё раз: на другую реплику после сбоя или дублем медленного запроса
+	Retry()
+	// запрос завершился по таймауту
+	Timeout()
+	// ответ взят из кэша, в том числе после ответа 304
+	CacheHit()
+}
+
+// ClientCounters - ClientMetrics, которая просто считает события и отдаёт их в формате Prometheus
+type ClientCounters struct {
+	attempts  int64
+	retries   int64
+	timeouts  int64
+	cacheHits int64
+
+	once     sync.Once
+	registry *prometheus.Registry
+}
+
+var _ ClientMetrics = (*ClientCounters)(nil)
+
+// ClientCounterStats - значения ClientCounters
+type ClientCounterStats struct {
+	Attempts  int64
+	Retries   int64
+	Timeouts  int64
+	CacheHits int64
+}
+
+func (c *ClientCounters) Attempt()  { atomic.AddInt64(&c.attempts, 1) }
+func (c *ClientCounters) Retry()    { atomic.AddInt64(&c.retries, 1) }
+func (c *ClientCounters) Timeout()  { atomic.AddInt64(&c.timeouts, 1) }
+func (c *ClientCounters) CacheHit() { atomic.AddInt64(&c.cacheHits, 1) }
+
+// Stats возвращает счётчики
+func (c *ClientCounters) Stats() ClientCounterStats {
+	return ClientCounterStats{
+		Attempts:  atomic.LoadInt64(&c.attempts),
+		Retries:   atomic.LoadInt64(&c.retries),
+		Timeouts:  atomic.LoadInt64(&c.timeouts),
+		CacheHits: atomic.LoadInt64(&c.cacheHits),
+	}
+}
+
+// ServeHTTP отдаёт счётчики в текстовом формате Prometheus
+func (c *ClientCounters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.once.Do(func() {
+		counter := func(name, help string, value *int64) prometheus.Collector {
+			return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+				return float64(atomic.LoadInt64(value))
+			})
+		}
+		c.registry = prometheus.NewRegistry()
+		c.registry.MustRegister(
+			counter("search_client_attempts_total", "HTTP-запросы SearchClient к серверу.", &c.attempts),
+			counter("search_client_retries_total", "Повторные запросы FindUsers.", &c.retries),
+			counter("search_client_timeouts_total", "Запросы, завершившиеся по таймауту.", &c.timeouts),
+			counter("search_client_cache_hits_total", "Ответы FindUsers из кэша.", &c.cacheHits),
+		)
+	})
+	promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// noClientMetrics - ClientMetrics клиента без метрик
+type noClientMetrics struct{}
+
+func (noClientMetrics) Attempt()  {}
+func (noClientMetrics) Retry()    {}
+func (noClientMetrics) Timeout()  {}
+func (noClientMetrics) CacheHit() {}
+
+func (srv *SearchClient) metrics() ClientMetrics {
+	if srv.Metrics == nil {
+		return noClientMetrics{}
+	}
+	return srv.Metrics
+}
+
+type attemptsContextKey struct{}
+
+// countAttempts отмечает начало вызова FindUsers: попытки после первой в его контексте считаются повторами
+func countAttempts(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attemptsContextKey{}, new(int32))
+}
+
+// metricsTransport сообщает ClientMetrics о каждом HTTP-запросе
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics ClientMetrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.metrics.Attempt()
+	if attempts, ok := req.Context().Value(attemptsContextKey{}).(*int32); ok && atomic.AddInt32(attempts, 1) > 1 {
+		t.metrics.Retry()
+	}
+	return t.next.RoundTrip(req)
+}
+
+// roundTripper возвращает транспорт клиента, который при заданных Metrics считает запросы
+func (srv *SearchClient) roundTripper() http.RoundTripper {
+	transport := srv.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if srv.Metrics == nil {
+		return transport
+	}
+	return &metricsTransport{next: transport, metrics: srv.Metrics}
+}
+
+// serverDatasets - датасет SearchServer без арендаторов для метрик
+func serverDatasets() []DatasetStats {
+	store, loadedAt, err := openServerStore()
+	if err != nil {
+		return nil
+	}
+	rows, err := store.CountUsers("")
+	if err != nil {
+		return nil
+	}
+	path := serverStore.Path
+	if path == "" {
+		path = datasetPath
+	}
+	return []DatasetStats{{Name: path, Rows: rows, LoadedAt: loadedAt}}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, url string) string {
+	resp, err := http.Get(url + metricsPath)
+	if err != nil {
+		t.Fatalf("cant scrape metrics: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/plain" || params["version"] != "0.0.4" {
+		t.Errorf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	return string(body)
+}
+
+func TestServerMetrics(t *testing.T) {
+	ts := httptest.NewServer(MetricsHandler(http.HandlerFunc(SearchServer), &ServerMetrics{Datasets: serverDatasets}))
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 2})
+	client.FindUsers(SearchRequest{OrderField: "bad"})
+	(&SearchClient{AccessToken: "bad", URL: ts.URL}).FindUsers(SearchRequest{Limit: 1})
+	(&SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Version: APIVersion2}).FindUsers(SearchRequest{OrderField: "bad"})
+
+	metrics := scrape(t, ts.URL)
+	for _, line := range []string{
+		`search_requests_total{code="200"} 2`,
+		`search_requests_total{code="400"} 2`,
+		`search_errors_total{code="ErrorBadOrderField",status="400"} 2`,
+		`search_errors_total{code="ErrorBadAccessToken",status="401"} 1`,
+		`search_request_duration_seconds_bucket{le="+Inf"} 5`,
+		`search_request_duration_seconds_count 5`,
+		`search_dataset_rows{dataset="./dataset.xml"} 35`,
+		"# TYPE search_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("metrics have no %q:\n%s", line, metrics)
+		}
+	}
+	if !strings.Contains(metrics, `search_dataset_loaded_timestamp_seconds{dataset="./dataset.xml"} `) {
+		t.Errorf("metrics have no dataset timestamp")
+	}
+	// сбор метрик сам не считается запросом
+	if !strings.Contains(scrape(t, ts.URL), "search_request_duration_seconds_count 5\n") {
+		t.Errorf("scrape must not be counted")
+	}
+}
+
+func TestServerMetricsTenants(t *testing.T) {
+	server, _ := newTestTenants(t)
+	ts := httptest.NewServer(MetricsHandler(server, &ServerMetrics{Datasets: server.Datasets}))
+	defer ts.Close()
+
+	before := time.Now()
+	server.Reload("small")
+	(&SearchClient{AccessToken: acmeToken, URL: ts.URL, Tenant: "missing"}).FindUsers(SearchRequest{Limit: 1})
+
+	metrics := scrape(t, ts.URL)
+	for _, line := range []string{
+		`search_errors_total{code="ErrorUnknownTenant",status="404"} 1`,
+		`search_dataset_rows{dataset="acme"} 35`,
+		`search_dataset_rows{dataset="small"} 10`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("metrics have no %q:\n%s", line, metrics)
+		}
+	}
+	datasets := server.Datasets()
+	if len(datasets) != 2 || datasets[1].Name != "small" || datasets[1].LoadedAt.Before(before) {
+		t.Errorf("expected reload time of small, got %+v", datasets)
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	// ответ, который кэш отдаёт без запроса
+	useCacheMaxAge(t, 5*time.Minute)
+	urls := newReplicas(t, &flakyServer{down: true}, &flakyServer{})
+	counters := &ClientCounters{}
+	client := &SearchClient{
+		AccessToken: serverAccessToken,
+		Balancer:    &LoadBalancer{URLs: urls},
+		Cache:       NewResponseCache(time.Minute, 10),
+		Metrics:     counters,
+	}
+
+	// первая реплика отвечает 500, запрос повторяется на второй
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if stats := counters.Stats(); stats.Attempts != 2 || stats.Retries != 1 || stats.CacheHits != 0 {
+		t.Errorf("unexpected stats after failover %+v", stats)
+	}
+	client.FindUsers(SearchRequest{Limit: 1})
+	if stats := counters.Stats(); stats.Attempts != 2 || stats.CacheHits != 1 {
+		t.Errorf("expected cache hit without attempts, got %+v", stats)
+	}
+
+	timeouts := &SearchClient{
+		AccessToken: serverAccessToken,
+		URL:         "http://search",
+		Metrics:     counters,
+		Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, &net.DNSError{Err: "timeout", IsTimeout: true}
+		}),
+	}
+	if _, err := timeouts.FindUsers(SearchRequest{Limit: 1}); err == nil || !strings.HasPrefix(err.Error(), "timeout for") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if stats := counters.Stats(); stats.Timeouts != 1 || stats.Attempts != 3 || stats.Retries != 1 {
+		t.Errorf("unexpected stats after timeout %+v", stats)
+	}
+
+	recorder := httptest.NewRecorder()
+	counters.ServeHTTP(recorder, httptest.NewRequest("GET", metricsPath, nil))
+	if !strings.Contains(recorder.Body.String(), "search_client_attempts_total 3\n") || !strings.Contains(recorder.Body.String(), "search_client_cache_hits_total 1\n") {
+		t.Errorf("unexpected client metrics:\n%s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCacheEntries = 1000
+
+// ResponseCache - кэш ответов FindUsers в SearchClient. Ответ отдаётся из кэша без запроса, пока он свежий:
+// в течение TTL, но не дольше max-age из Cache-Control ответа, а с no-cache - ни одного запроса.
+// Потом он перепроверяется запросом с If-None-Match, и если сервер ответил 304 - снова берётся из кэша.
+// Ключ - нормализованный SearchRequest, адрес, версия API и хэш токена, так что ответы разных токенов не смешиваются
+type ResponseCache struct {
+	// сколько ответ считается свежим, если сервер не ограничил это сильнее; 0 - перепроверять при каждом запросе
+	TTL time.Duration
+	// сколько ответов хранится, самые давно использованные вытесняются; 0 - defaultCacheEntries
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// порядок использования, в начале - самые свежие
+	lru   *list.List
+	stats CacheStats
+	// часы, подменяются в тестах
+	now func() time.Time
+}
+
+// CacheStats - счётчики работы кэша
+type CacheStats struct {
+	// ответы, отданные без запроса
+	Hits int
+	// ответы, подтверждённые сервером через 304
+	Revalidated int
+	// ответы, полученные от сервера целиком и сохранённые в кэш
+	Misses int
+	// вытесненные по размеру записи
+	Evictions int
+}
+
+type cacheEntry struct {
+	key      string
+	response *SearchResponse
+	etag     string
+	expires  time.Time
+	// директивы Cache-Control сохранённого ответа
+	cacheControl map[string]string
+}
+
+// NewResponseCache создаёт кэш с заданным временем жизни и размером
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{TTL: ttl, MaxEntries: maxEntries}
+}
+
+// Stats возвращает счётчики кэша
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Len возвращает количество ответов в кэше
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Len()
+}
+
+// Clear удаляет все ответы
+func (c *ResponseCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries, c.lru = nil, nil
+}
+
+func (c *ResponseCache) init() {
+	if c.lru == nil {
+		c.entries = map[string]*list.Element{}
+		c.lru = list.New()
+	}
+	if c.now == nil {
+		c.now = time.Now
+	}
+}
+
+// responseCacheKey строит ключ кэша; токен попадает в ключ только хэшем
+func responseCacheKey(method, target, accept, token string, req SearchRequest) string {
+	scope := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%s %s|%s|%s|%d|%d|%q|%q|%d", method, target, accept, hex.EncodeToString(scope[:8]),
+		req.Limit, req.Offset, req.Query, req.OrderField, req.OrderBy)
+}
+
+// get возвращает свежий ответ, а если он устарел, но у него есть ETag - запись для перепроверки
+func (c *ResponseCache) get(key string) (*SearchResponse, *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := element.Value.(*cacheEntry)
+	c.lru.MoveToFront(element)
+	if c.now().Before(entry.expires) {
+		c.stats.Hits++
+		return copyResponse(entry.response), nil
+	}
+	if entry.etag == "" {
+		return nil, nil
+	}
+	return nil, entry
+}
+
+// revalidated продлевает запись после ответа 304 и возвращает сохранённый ответ
+func (c *ResponseCache) revalidated(entry *cacheEntry, header http.Header) *SearchResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	c.stats.Revalidated++
+
+	// записи не меняются после сохранения: etag читается вне блокировки
+	updated := *entry
+	if etag := header.Get("ETag"); etag != "" {
+		updated.etag = etag
+	}
+	// 304 обновляет заголовки сохранённого ответа, в том числе Cache-Control
+	cacheControl := parseCacheControl(header)
+	if cacheControl == nil {
+		cacheControl = entry.cacheControl
+	}
+	if _, noStore := cacheControl["no-store"]; noStore {
+		c.remove(entry.key)
+		return copyResponse(updated.response)
+	}
+	updated.cacheControl = cacheControl
+	updated.expires = c.now().Add(c.freshFor(cacheControl))
+	c.store(&updated)
+	return copyResponse(updated.response)
+}
+
+// put сохраняет успешный ответ, если сервер не запретил его хранить через Cache-Control: no-store
+func (c *ResponseCache) put(key string, response *SearchResponse, header http.Header) {
+	cacheControl := parseCacheControl(header)
+	if _, noStore := cacheControl["no-store"]; noStore {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	c.stats.Misses++
+	c.store(&cacheEntry{
+		key:      key,
+		response: copyResponse(response),
+		etag:     header.Get("ETag"),
+		expires:  c.now().Add(c.freshFor(cacheControl)),
+
+		cacheControl: cacheControl,
+	})
+}
+
+// freshFor - сколько ответ можно отдавать без перепроверки: TTL, урезанный до max-age, с no-cache - нисколько
+func (c *ResponseCache) freshFor(cacheControl map[string]string) time.Duration {
+	if _, noCache := cacheControl["no-cache"]; noCache {
+		return 0
+	}
+	ttl := c.TTL
+	if value, ok := cacheControl["max-age"]; ok {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			// неразбираемый max-age по RFC 9111 означает, что ответ уже устарел
+			return 0
+		}
+		if maxAge := time.Duration(seconds) * time.Second; maxAge < ttl {
+			ttl = maxAge
+		}
+	}
+	return ttl
+}
+
+// parseCacheControl разбирает директивы Cache-Control в map имя -> значение, nil - заголовка нет
+func parseCacheControl(header http.Header) map[string]string {
+	values := header.Values("Cache-Control")
+	if len(values) == 0 {
+		return nil
+	}
+	directives := map[string]string{}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(argument, `"`)
+		}
+	}
+	return directives
+}
+
+// remove удаляет запись; вызывается под c.mu
+func (c *ResponseCache) remove(key string) {
+	if element, ok := c.entries[key]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// store кладёт запись в начало очереди и вытесняет лишние; вызывается под c.mu
+func (c *ResponseCache) store(entry *cacheEntry) {
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[entry.key] = c.lru.PushFront(entry)
+	}
+
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	for c.lru.Len() > maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// copyResponse отдаёт копию, чтобы изменения у вызывающего не портили кэш
+func copyResponse(response *SearchResponse) *SearchResponse {
+	result := *response
+	if response.Users != nil {
+		result.Users = append([]User(nil), response.Users...)
+	}
+	return &result
+}
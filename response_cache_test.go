@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// etagServer - SearchServer, который ставит ETag по телу ответа и отвечает 304 на совпавший If-None-Match
+type etagServer struct {
+	mu           sync.Mutex
+	requests     int
+	conditional  int
+	cacheControl string
+	noETag       bool
+}
+
+func (s *etagServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	if r.Header.Get("If-None-Match") != "" {
+		s.conditional++
+	}
+	s.mu.Unlock()
+
+	recorder := httptest.NewRecorder()
+	SearchServer(recorder, r)
+	sum := sha256.Sum256(recorder.Body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	for key, values := range recorder.Header() {
+		w.Header()[key] = values
+	}
+	if s.cacheControl != "" {
+		w.Header().Set("Cache-Control", s.cacheControl)
+	}
+	if s.noETag {
+		// SearchServer сам ставит ETag, а здесь нужен ответ без него
+		w.Header().Del("ETag")
+	} else {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(recorder.Code)
+	w.Write(recorder.Body.Bytes())
+}
+
+func (s *etagServer) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.conditional
+}
+
+// testClock - часы кэша, которые двигаются вручную
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// freshForMinutes - Cache-Control, при котором свежесть ответа ограничивает TTL кэша, а не сервер.
+// SearchServer сам отвечает с no-cache, и без этого каждый повтор шёл бы на сервер за 304
+const freshForMinutes = "max-age=300"
+
+func newCachedClient(t *testing.T, handler http.Handler, cache *ResponseCache) (*SearchClient, *testClock) {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cache.now = clock.Now
+	return &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Cache: cache}, clock
+}
+
+func TestResponseCacheTTLAndRevalidation(t *testing.T) {
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		server := &etagServer{cacheControl: freshForMinutes}
+		client, clock := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+		client.Version = version
+		req := SearchRequest{Limit: 5, OrderField: "id", OrderBy: OrderByAsc}
+
+		first, err := client.FindUsers(req)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %s", version, err)
+		}
+		second, err := client.FindUsers(req)
+		if err != nil || len(second.Users) != 5 || second.Users[0] != first.Users[0] || !second.NextPage {
+			t.Errorf("[%s] unexpected cached result %v %v", version, second, err)
+		}
+		if requests, _ := server.counts(); requests != 1 {
+			t.Errorf("[%s] expected 1 request within TTL, got %d", version, requests)
+		}
+
+		clock.now = clock.now.Add(2 * time.Minute)
+		third, err := client.FindUsers(req)
+		if err != nil || len(third.Users) != 5 || third.Users[4] != first.Users[4] {
+			t.Errorf("[%s] unexpected revalidated result %v %v", version, third, err)
+		}
+		if requests, conditional := server.counts(); requests != 2 || conditional != 1 {
+			t.Errorf("[%s] expected conditional request, got %d/%d", version, requests, conditional)
+		}
+
+		// после 304 ответ снова свежий
+		client.FindUsers(req)
+		stats := client.Cache.Stats()
+		if stats.Hits != 2 || stats.Revalidated != 1 || stats.Misses != 1 {
+			t.Errorf("[%s] unexpected stats %+v", version, stats)
+		}
+	}
+}
+
+func TestResponseCacheKeys(t *testing.T) {
+	server := &etagServer{cacheControl: freshForMinutes}
+	client, _ := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+
+	client.FindUsers(SearchRequest{Limit: 5})
+	// лимит больше 25 нормализуется, поэтому это тот же запрос
+	client.FindUsers(SearchRequest{Limit: 30})
+	client.FindUsers(SearchRequest{Limit: 25})
+	if requests, _ := server.counts(); requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+
+	// другой токен - другой ключ, а ошибки не кэшируются
+	other := *client
+	other.AccessToken = "bad"
+	for i := 0; i < 2; i++ {
+		if _, err := other.FindUsers(SearchRequest{Limit: 5}); err == nil {
+			t.Errorf("expected Bad AccessToken")
+		}
+	}
+	if requests, _ := server.counts(); requests != 4 {
+		t.Errorf("expected token to be part of the key, got %d requests", requests)
+	}
+	if client.Cache.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", client.Cache.Len())
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	server := &etagServer{cacheControl: freshForMinutes}
+	client, _ := newCachedClient(t, server, NewResponseCache(time.Minute, 2))
+
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 2})
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 3})
+	// Limit 2 использовался давнее всех и вытеснен
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 2})
+
+	if requests, _ := server.counts(); requests != 4 {
+		t.Errorf("expected 4 requests, got %d", requests)
+	}
+	if stats := client.Cache.Stats(); stats.Evictions != 2 || client.Cache.Len() != 2 {
+		t.Errorf("unexpected stats %+v, len %d", stats, client.Cache.Len())
+	}
+
+	client.Cache.Clear()
+	client.FindUsers(SearchRequest{Limit: 1})
+	if requests, _ := server.counts(); requests != 5 {
+		t.Errorf("expected request after Clear, got %d", requests)
+	}
+}
+
+func TestResponseCacheHonoursCacheControl(t *testing.T) {
+	// no-cache от SearchServer: ответ хранится, но каждый повтор перепроверяется, даже в пределах TTL
+	server := &etagServer{}
+	client, _ := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+	first, _ := client.FindUsers(SearchRequest{Limit: 3})
+	second, err := client.FindUsers(SearchRequest{Limit: 3})
+	if err != nil || second.Users[2] != first.Users[2] {
+		t.Errorf("unexpected revalidated result %v %v", second, err)
+	}
+	if requests, conditional := server.counts(); requests != 2 || conditional != 1 {
+		t.Errorf("no-cache response must be revalidated, got %d/%d", requests, conditional)
+	}
+	if stats := client.Cache.Stats(); stats.Hits != 0 || stats.Revalidated != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// max-age короче TTL: свежим ответ остаётся только max-age
+	server = &etagServer{cacheControl: "public, max-age=30"}
+	client, clock := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+	client.FindUsers(SearchRequest{Limit: 3})
+	clock.now = clock.now.Add(20 * time.Second)
+	client.FindUsers(SearchRequest{Limit: 3})
+	if requests, _ := server.counts(); requests != 1 {
+		t.Errorf("expected cached response within max-age, got %d requests", requests)
+	}
+	clock.now = clock.now.Add(20 * time.Second)
+	client.FindUsers(SearchRequest{Limit: 3})
+	if requests, conditional := server.counts(); requests != 2 || conditional != 1 {
+		t.Errorf("expected revalidation after max-age, got %d/%d", requests, conditional)
+	}
+
+	// 304 с no-store убирает ответ из кэша
+	server.cacheControl = "no-store"
+	clock.now = clock.now.Add(time.Minute)
+	if result, err := client.FindUsers(SearchRequest{Limit: 3}); err != nil || len(result.Users) != 3 {
+		t.Errorf("unexpected result %v %v", result, err)
+	}
+	if client.Cache.Len() != 0 {
+		t.Errorf("expected no-store on 304 to drop the entry, got %d entries", client.Cache.Len())
+	}
+}
+
+func TestResponseCacheNoStoreAndNoETag(t *testing.T) {
+	server := &etagServer{cacheControl: "no-store"}
+	client, _ := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 1})
+	if requests, _ := server.counts(); requests != 2 || client.Cache.Len() != 0 {
+		t.Errorf("no-store responses must not be cached, got %d requests", requests)
+	}
+
+	server = &etagServer{noETag: true}
+	client, clock := newCachedClient(t, server, NewResponseCache(time.Minute, 10))
+	client.FindUsers(SearchRequest{Limit: 1})
+	clock.now = clock.now.Add(2 * time.Minute)
+	client.FindUsers(SearchRequest{Limit: 1})
+	if requests, conditional := server.counts(); requests != 2 || conditional != 0 {
+		t.Errorf("expected full request without ETag, got %d/%d", requests, conditional)
+	}
+}
+
+func TestResponseCacheReturnsCopies(t *testing.T) {
+	client, _ := newCachedClient(t, &etagServer{}, NewResponseCache(time.Minute, 10))
+	first, _ := client.FindUsers(SearchRequest{Limit: 2})
+	first.Users[0].Name = "changed"
+	second, _ := client.FindUsers(SearchRequest{Limit: 2})
+	if second.Users[0].Name == "changed" {
+		t.Errorf("cached response was modified through the returned value")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// максимальный размер JSON-тела поискового запроса
+const maxSearchBodySize = 1 << 20
+
+// имена полей SearchRequest в терминах параметров GET-запроса
+var searchBodyFields = map[string]string{
+	"Limit":      "limit",
+	"Offset":     "offset",
+	"Query":      "query",
+	"OrderField": "order_field",
+	"OrderBy":    "order_by",
+}
+
+// searchBody - JSON-тело POST-запроса. Указатели нужны, чтобы отличить отсутствующее поле от нуля
+type searchBody struct {
+	Limit      *int
+	Offset     *int
+	Query      string
+	OrderField string
+	OrderBy    *int
+}
+
+// validationError - список ошибок валидации по полям тела запроса
+type validationError []SearchFieldError
+
+func (e validationError) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, field := range e {
+		parts = append(parts, field.Field+": "+field.Message)
+	}
+	return "invalid request body: " + strings.Join(parts, "; ")
+}
+
+func isJSONBody(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// parseSearchBody разбирает и валидирует JSON-тело POST-запроса.
+// Если невалидно только поле OrderField, возвращает errBadOrderField, как и GET-запрос
+func parseSearchBody(r *http.Request) (searchParams, error) {
+	params := searchParams{Limit: 10}
+
+	body := searchBody{}
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxSearchBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		return params, validationError{decodeFieldError(err)}
+	}
+
+	var errs validationError
+	if body.Limit != nil {
+		params.Limit = *body.Limit
+	}
+	if params.Limit < 0 {
+		errs = append(errs, SearchFieldError{Field: "limit", Message: "must not be negative"})
+	}
+	if body.Offset != nil {
+		params.Offset = *body.Offset
+	}
+	if params.Offset < 0 {
+		errs = append(errs, SearchFieldError{Field: "offset", Message: "must not be negative"})
+	}
+	if body.OrderBy != nil {
+		params.OrderBy = *body.OrderBy
+	}
+	if params.OrderBy < OrderByAsc || params.OrderBy > OrderByDesc {
+		errs = append(errs, SearchFieldError{Field: "order_by", Message: "must be one of -1, 0, 1"})
+	}
+	params.Query = body.Query
+
+	orderField, err := normalizeOrderField(body.OrderField)
+	params.OrderField = orderField
+	if err != nil {
+		if len(errs) == 0 {
+			return params, errBadOrderField
+		}
+		errs = append(errs, SearchFieldError{Field: "order_field", Message: "must be one of id, age, name"})
+	}
+
+	if len(errs) > 0 {
+		return params, errs
+	}
+	return params, nil
+}
+
+func decodeFieldError(err error) SearchFieldError {
+	switch err := err.(type) {
+	case *json.UnmarshalTypeError:
+		field, ok := searchBodyFields[err.Field]
+		if !ok {
+			field = err.Field
+		}
+		return SearchFieldError{Field: field, Message: "must be " + err.Type.String()}
+	case *http.MaxBytesError:
+		return SearchFieldError{Field: "body", Message: "must not exceed " + strconv.Itoa(maxSearchBodySize) + " bytes"}
+	}
+	if name := strings.TrimPrefix(err.Error(), "json: unknown field "); name != err.Error() {
+		name, _ = strconv.Unquote(name)
+		return SearchFieldError{Field: name, Message: "unknown field"}
+	}
+	return SearchFieldError{Field: "body", Message: err.Error()}
+}
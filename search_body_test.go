@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchClientSwitchesToPost(t *testing.T) {
+	cases := []struct {
+		Query  SearchRequest
+		Method string
+		Result *SearchResponse
+	}{
+		{
+			Query:  SearchRequest{Limit: 1, Query: "Aguilar", OrderField: "Name"},
+			Method: "GET",
+			Result: tResultCase2().Result,
+		},
+		{
+			Query:  SearchRequest{Limit: 1, Query: "Aguilar" + strings.Repeat(" ", 3000), OrderField: "Name"},
+			Method: "POST",
+			Result: &SearchResponse{},
+		},
+	}
+
+	var method string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		SearchServer(w, r)
+	}))
+
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		for caseNum, item := range cases {
+			s := &SearchClient{
+				AccessToken: serverAccessToken,
+				URL:         ts.URL,
+				Version:     version,
+			}
+			result, err := s.FindUsers(item.Query)
+
+			if err != nil {
+				t.Errorf("[%s/%d] unexpected error: %#v", version, caseNum, err)
+			}
+			if method != item.Method {
+				t.Errorf("[%s/%d] wrong method, expected %s, got %s", version, caseNum, item.Method, method)
+			}
+			if len(item.Result.Users) != len(result.Users) || (len(item.Result.Users) > 0 && !reflect.DeepEqual(item.Result, result)) {
+				t.Errorf("[%s/%d] wrong result, expected %#v\n, got \n %#v", version, caseNum, item.Result, result)
+			}
+		}
+	}
+	ts.Close()
+}
+
+func TestSearchServerPostBody(t *testing.T) {
+	cases := []struct {
+		Body   string
+		Status int
+		Users  []int
+		Error  SearchErrorDetail
+	}{
+		{
+			Body:   `{"Limit": 3, "OrderField": "Id", "OrderBy": 1}`,
+			Status: http.StatusOK,
+			Users:  []int{34, 33, 32},
+		},
+		{
+			Body:   `{"limit": 2, "offset": 1, "query": "Aguilar"}`,
+			Status: http.StatusOK,
+			Users:  []int{},
+		},
+		{
+			Body:   `{"OrderField": "picture"}`,
+			Status: http.StatusBadRequest,
+			Error:  SearchErrorDetail{Code: ErrorCodeBadOrderField, Message: "order_field must be one of id, age, name", Field: "order_field"},
+		},
+		{
+			Body:   `{"Limit": -1, "Offset": -2, "OrderBy": 5, "OrderField": "picture"}`,
+			Status: http.StatusBadRequest,
+			Error: SearchErrorDetail{Code: ErrorCodeBadParam, Message: "request body is invalid", Details: []SearchFieldError{
+				{Field: "limit", Message: "must not be negative"},
+				{Field: "offset", Message: "must not be negative"},
+				{Field: "order_by", Message: "must be one of -1, 0, 1"},
+				{Field: "order_field", Message: "must be one of id, age, name"},
+			}},
+		},
+		{
+			Body:   `{"Limit": "ten"}`,
+			Status: http.StatusBadRequest,
+			Error: SearchErrorDetail{Code: ErrorCodeBadParam, Message: "request body is invalid", Details: []SearchFieldError{
+				{Field: "limit", Message: "must be int"},
+			}},
+		},
+		{
+			Body:   `{"Picture": "x"}`,
+			Status: http.StatusBadRequest,
+			Error: SearchErrorDetail{Code: ErrorCodeBadParam, Message: "request body is invalid", Details: []SearchFieldError{
+				{Field: "Picture", Message: "unknown field"},
+			}},
+		},
+		{
+			Body:   `notajson`,
+			Status: http.StatusBadRequest,
+			Error: SearchErrorDetail{Code: ErrorCodeBadParam, Message: "request body is invalid", Details: []SearchFieldError{
+				{Field: "body", Message: "invalid character 'o' in literal null (expecting 'u')"},
+			}},
+		},
+		{
+			Body:   `{"Query": "` + strings.Repeat("x", maxSearchBodySize) + `"}`,
+			Status: http.StatusBadRequest,
+			Error: SearchErrorDetail{Code: ErrorCodeBadParam, Message: "request body is invalid", Details: []SearchFieldError{
+				{Field: "body", Message: "must not exceed 1048576 bytes"},
+			}},
+		},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+
+	for caseNum, item := range cases {
+		req, _ := http.NewRequest("POST", ts.URL+"/v2", strings.NewReader(item.Body))
+		req.Header.Set("AccessToken", serverAccessToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %#v", caseNum, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] wrong status, expected %d, got %d", caseNum, item.Status, resp.StatusCode)
+		}
+
+		if item.Status != http.StatusOK {
+			errResp := SearchErrorResponseV2{}
+			json.Unmarshal(body, &errResp)
+			if !reflect.DeepEqual(item.Error, errResp.Error) {
+				t.Errorf("[%d] wrong error, expected %#v, got %#v", caseNum, item.Error, errResp.Error)
+			}
+			continue
+		}
+
+		result := SearchResponseV2{}
+		json.Unmarshal(body, &result)
+		ids := []int{}
+		for _, user := range result.Users {
+			ids = append(ids, user.Id)
+		}
+		if !reflect.DeepEqual(item.Users, ids) {
+			t.Errorf("[%d] wrong users, expected %v, got %v", caseNum, item.Users, ids)
+		}
+	}
+	ts.Close()
+}
@@ -0,0 +1,310 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: searchpb/search.proto
+
+package searchpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SearchRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Limit  int32                  `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset int32                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	// подстрока в имени или в поле about
+	Query string `protobuf:"bytes,3,opt,name=query,proto3" json:"query,omitempty"`
+	// id, age, name; пустое значение - name
+	OrderField string `protobuf:"bytes,4,opt,name=order_field,json=orderField,proto3" json:"order_field,omitempty"`
+	// -1 по возрастанию, 0 как встретилось, 1 по убыванию
+	OrderBy       int32 `protobuf:"varint,5,opt,name=order_by,json=orderBy,proto3" json:"order_by,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_searchpb_search_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_searchpb_search_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_searchpb_search_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SearchRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetOrderField() string {
+	if x != nil {
+		return x.OrderField
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetOrderBy() int32 {
+	if x != nil {
+		return x.OrderBy
+	}
+	return 0
+}
+
+type User struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int32                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Age           int32                  `protobuf:"varint,3,opt,name=age,proto3" json:"age,omitempty"`
+	About         string                 `protobuf:"bytes,4,opt,name=about,proto3" json:"about,omitempty"`
+	Gender        string                 `protobuf:"bytes,5,opt,name=gender,proto3" json:"gender,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *User) Reset() {
+	*x = User{}
+	mi := &file_searchpb_search_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *User) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*User) ProtoMessage() {}
+
+func (x *User) ProtoReflect() protoreflect.Message {
+	mi := &file_searchpb_search_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use User.ProtoReflect.Descriptor instead.
+func (*User) Descriptor() ([]byte, []int) {
+	return file_searchpb_search_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *User) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *User) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *User) GetAge() int32 {
+	if x != nil {
+		return x.Age
+	}
+	return 0
+}
+
+func (x *User) GetAbout() string {
+	if x != nil {
+		return x.About
+	}
+	return ""
+}
+
+func (x *User) GetGender() string {
+	if x != nil {
+		return x.Gender
+	}
+	return ""
+}
+
+type SearchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	NextPage      bool                   `protobuf:"varint,2,opt,name=next_page,json=nextPage,proto3" json:"next_page,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchResponse) Reset() {
+	*x = SearchResponse{}
+	mi := &file_searchpb_search_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResponse) ProtoMessage() {}
+
+func (x *SearchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_searchpb_search_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResponse.ProtoReflect.Descriptor instead.
+func (*SearchResponse) Descriptor() ([]byte, []int) {
+	return file_searchpb_search_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SearchResponse) GetUsers() []*User {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+func (x *SearchResponse) GetNextPage() bool {
+	if x != nil {
+		return x.NextPage
+	}
+	return false
+}
+
+var File_searchpb_search_proto protoreflect.FileDescriptor
+
+const file_searchpb_search_proto_rawDesc = "" +
+	"\n" +
+	"\x15searchpb/search.proto\x12\x06search\"\x8f\x01\n" +
+	"\rSearchRequest\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x05R\x06offset\x12\x14\n" +
+	"\x05query\x18\x03 \x01(\tR\x05query\x12\x1f\n" +
+	"\vorder_field\x18\x04 \x01(\tR\n" +
+	"orderField\x12\x19\n" +
+	"\border_by\x18\x05 \x01(\x05R\aorderBy\"j\n" +
+	"\x04User\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x05R\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x10\n" +
+	"\x03age\x18\x03 \x01(\x05R\x03age\x12\x14\n" +
+	"\x05about\x18\x04 \x01(\tR\x05about\x12\x16\n" +
+	"\x06gender\x18\x05 \x01(\tR\x06gender\"Q\n" +
+	"\x0eSearchResponse\x12\"\n" +
+	"\x05users\x18\x01 \x03(\v2\f.search.UserR\x05users\x12\x1b\n" +
+	"\tnext_page\x18\x02 \x01(\bR\bnextPage2~\n" +
+	"\n" +
+	"UserSearch\x12:\n" +
+	"\tFindUsers\x12\x15.search.SearchRequest\x1a\x16.search.SearchResponse\x124\n" +
+	"\vStreamUsers\x12\x15.search.SearchRequest\x1a\f.search.User0\x01B:Z8github.com/asannikov/golang-webservices-1-week4/searchpbb\x06proto3"
+
+var (
+	file_searchpb_search_proto_rawDescOnce sync.Once
+	file_searchpb_search_proto_rawDescData []byte
+)
+
+func file_searchpb_search_proto_rawDescGZIP() []byte {
+	file_searchpb_search_proto_rawDescOnce.Do(func() {
+		file_searchpb_search_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_searchpb_search_proto_rawDesc), len(file_searchpb_search_proto_rawDesc)))
+	})
+	return file_searchpb_search_proto_rawDescData
+}
+
+var file_searchpb_search_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_searchpb_search_proto_goTypes = []any{
+	(*SearchRequest)(nil),  // 0: search.SearchRequest
+	(*User)(nil),           // 1: search.User
+	(*SearchResponse)(nil), // 2: search.SearchResponse
+}
+var file_searchpb_search_proto_depIdxs = []int32{
+	1, // 0: search.SearchResponse.users:type_name -> search.User
+	0, // 1: search.UserSearch.FindUsers:input_type -> search.SearchRequest
+	0, // 2: search.UserSearch.StreamUsers:input_type -> search.SearchRequest
+	2, // 3: search.UserSearch.FindUsers:output_type -> search.SearchResponse
+	1, // 4: search.UserSearch.StreamUsers:output_type -> search.User
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_searchpb_search_proto_init() }
+func file_searchpb_search_proto_init() {
+	if File_searchpb_search_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_searchpb_search_proto_rawDesc), len(file_searchpb_search_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_searchpb_search_proto_goTypes,
+		DependencyIndexes: file_searchpb_search_proto_depIdxs,
+		MessageInfos:      file_searchpb_search_proto_msgTypes,
+	}.Build()
+	File_searchpb_search_proto = out.File
+	file_searchpb_search_proto_goTypes = nil
+	file_searchpb_search_proto_depIdxs = nil
+}
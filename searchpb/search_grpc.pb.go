@@ -0,0 +1,173 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: searchpb/search.proto
+
+package searchpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	UserSearch_FindUsers_FullMethodName   = "/search.UserSearch/FindUsers"
+	UserSearch_StreamUsers_FullMethodName = "/search.UserSearch/StreamUsers"
+)
+
+// UserSearchClient is the client API for UserSearch service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// UserSearch повторяет SearchClient.FindUsers поверх gRPC.
+// Токен авторизации передаётся в метаданных под ключом accesstoken
+type UserSearchClient interface {
+	// FindUsers возвращает одну страницу результатов
+	FindUsers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// StreamUsers отдаёт все подходящие записи потоком, limit = 0 - без ограничения
+	StreamUsers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[User], error)
+}
+
+type userSearchClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUserSearchClient(cc grpc.ClientConnInterface) UserSearchClient {
+	return &userSearchClient{cc}
+}
+
+func (c *userSearchClient) FindUsers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, UserSearch_FindUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userSearchClient) StreamUsers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[User], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &UserSearch_ServiceDesc.Streams[0], UserSearch_StreamUsers_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SearchRequest, User]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type UserSearch_StreamUsersClient = grpc.ServerStreamingClient[User]
+
+// UserSearchServer is the server API for UserSearch service.
+// All implementations must embed UnimplementedUserSearchServer
+// for forward compatibility.
+//
+// UserSearch повторяет SearchClient.FindUsers поверх gRPC.
+// Токен авторизации передаётся в метаданных под ключом accesstoken
+type UserSearchServer interface {
+	// FindUsers возвращает одну страницу результатов
+	FindUsers(context.Context, *SearchRequest) (*SearchResponse, error)
+	// StreamUsers отдаёт все подходящие записи потоком, limit = 0 - без ограничения
+	StreamUsers(*SearchRequest, grpc.ServerStreamingServer[User]) error
+	mustEmbedUnimplementedUserSearchServer()
+}
+
+// UnimplementedUserSearchServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedUserSearchServer struct{}
+
+func (UnimplementedUserSearchServer) FindUsers(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindUsers not implemented")
+}
+func (UnimplementedUserSearchServer) StreamUsers(*SearchRequest, grpc.ServerStreamingServer[User]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamUsers not implemented")
+}
+func (UnimplementedUserSearchServer) mustEmbedUnimplementedUserSearchServer() {}
+func (UnimplementedUserSearchServer) testEmbeddedByValue()                    {}
+
+// UnsafeUserSearchServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UserSearchServer will
+// result in compilation errors.
+type UnsafeUserSearchServer interface {
+	mustEmbedUnimplementedUserSearchServer()
+}
+
+func RegisterUserSearchServer(s grpc.ServiceRegistrar, srv UserSearchServer) {
+	// If the following call pancis, it indicates UnimplementedUserSearchServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&UserSearch_ServiceDesc, srv)
+}
+
+func _UserSearch_FindUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserSearchServer).FindUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserSearch_FindUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserSearchServer).FindUsers(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserSearch_StreamUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserSearchServer).StreamUsers(m, &grpc.GenericServerStream[SearchRequest, User]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type UserSearch_StreamUsersServer = grpc.ServerStreamingServer[User]
+
+// UserSearch_ServiceDesc is the grpc.ServiceDesc for UserSearch service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UserSearch_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "search.UserSearch",
+	HandlerType: (*UserSearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FindUsers",
+			Handler:    _UserSearch_FindUsers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamUsers",
+			Handler:       _UserSearch_StreamUsers_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "searchpb/search.proto",
+}
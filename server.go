@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const serverAccessToken = "1234567890"
+
+// датасет, который читает SearchServer
+var datasetPath = "./dataset.xml"
+
+var (
+	errBadOrderField = errors.New("wrong_order_field_paramter")
+	errNegative      = errors.New("must not be negative")
+	errUnknownFormat = errors.New("must be one of json, csv, xml")
+)
+
+type UserXml struct {
+	ID        int    `xml:"id" json:"id"`
+	GUID      string `xml:"guid" json:"guid"`
+	Active    bool   `xml:"isActive" json:"isActive"`
+	Balance   string `xml:"balance" json:"balance"`
+	Picture   string `xml:"picture" json:"picture"`
+	Age       int    `xml:"age" json:"age"`
+	EyeColor  string `xml:"eyeColor" json:"eyeColor"`
+	FirstName string `xml:"first_name" json:"first_name"`
+	LastName  string `xml:"last_name" json:"last_name"`
+	Gender    string `xml:"gender" json:"gender"`
+	Company   string `xml:"company" json:"company"`
+	Email     string `xml:"email" json:"email"`
+	Phone     string `xml:"phone" json:"phone"`
+	Address   string `xml:"address" json:"address"`
+	About     string `xml:"about" json:"about"`
+}
+
+// Matches проверяет, встречается ли query в имени или в поле About
+func (u UserXml) Matches(query string) bool {
+	return query == "" || strings.Contains(u.FirstName+" "+u.LastName, query) || strings.Contains(u.About, query)
+}
+
+// User - запись в том виде, в котором её отдаёт поиск
+func (u UserXml) User() User {
+	return User{
+		Id:     u.ID,
+		Name:   u.FirstName + " " + u.LastName,
+		Age:    u.Age,
+		About:  u.About,
+		Gender: u.Gender,
+	}
+}
+
+// Name sort
+type UserNameSort []UserXml
+
+func (slice UserNameSort) Len() int {
+	return len(slice)
+}
+
+func (slice UserNameSort) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+func (slice UserNameSort) Less(i, j int) bool {
+	return slice[i].FirstName+" "+slice[i].LastName < slice[j].FirstName+" "+slice[j].LastName
+}
+
+// Age sort
+type UserAgeSort []UserXml
+
+func (slice UserAgeSort) Len() int {
+	return len(slice)
+}
+
+func (slice UserAgeSort) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+func (slice UserAgeSort) Less(i, j int) bool {
+	return slice[i].Age < slice[j].Age
+}
+
+// Id sort
+type UserIdSOrt []UserXml
+
+func (slice UserIdSOrt) Len() int {
+	return len(slice)
+}
+
+func (slice UserIdSOrt) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+func (slice UserIdSOrt) Less(i, j int) bool {
+	return slice[i].ID < slice[j].ID
+}
+
+type Users struct {
+	List []UserXml `xml:"row"`
+	// индексы сортировки, построенные при загрузке с LoadOptions.Index; их забирает NewIndexedStore
+	index *sortIndex
+}
+
+func (usr *Users) FindUsers(query string, orderField string, limit int, offset int, soryby int) []User {
+	var userList []User
+
+	for _, userEnt := range usr.FindRows(query, orderField, limit, offset, soryby) {
+		userList = append(userList, userEnt.User())
+	}
+
+	return userList
+}
+
+// FindRows - то же, что FindUsers, но возвращает исходные записи датасета со всеми полями
+func (usr *Users) FindRows(query string, orderField string, limit int, offset int, soryby int) []UserXml {
+
+	if soryby != 0 {
+		// сортировка на месте сдвигает записи, и позиции в индексах больше не верны
+		usr.index = nil
+		if orderField == "name" {
+			result := UserNameSort(usr.List)
+			if soryby == 1 {
+				sort.Sort(sort.Reverse(result))
+			} else {
+				sort.Sort(result)
+			}
+		} else if orderField == "age" {
+			result := UserAgeSort(usr.List)
+			if soryby == 1 {
+				sort.Sort(sort.Reverse(result))
+			} else {
+				sort.Sort(result)
+			}
+		} else if orderField == "id" {
+			result := UserIdSOrt(usr.List)
+			if soryby == 1 {
+				sort.Sort(sort.Reverse(result))
+			} else {
+				sort.Sort(result)
+			}
+		}
+	}
+
+	var rowList []UserXml
+
+	k := 0
+	i := 0
+	for _, userEnt := range usr.List {
+		if k == limit {
+			break
+		}
+		if !userEnt.Matches(query) {
+			continue
+		}
+
+		if i >= offset {
+			rowList = append(rowList, userEnt)
+			k++
+		}
+		i++
+	}
+
+	return rowList
+}
+
+// CountUsers возвращает количество записей, подходящих под query, без учёта пагинации
+func (usr *Users) CountUsers(query string) int {
+	total := 0
+	for _, userEnt := range usr.List {
+		if userEnt.Matches(query) {
+			total++
+		}
+	}
+	return total
+}
+
+func loadUsers() (Users, error) {
+	file, err := os.Open(datasetPath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	users, _, err := LoadUsers(file, LoadOptions{RowError: rowErrorLog(datasetPath)})
+	return users, err
+}
+
+// loadUsersFrom читает датасет в формате dataset.xml из произвольного файла
+func loadUsersFrom(path string) (Users, error) {
+	return loadUsersFile(path, LoadOptions{RowError: rowErrorLog(path)})
+}
+
+// loadUsersFile - loadUsersFrom с параметрами загрузки
+func loadUsersFile(path string, opts LoadOptions) (Users, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Users{List: []UserXml{}}, err
+	}
+	defer file.Close()
+	users, _, err := LoadUsers(file, opts)
+	return users, err
+}
+
+func parseUsers(xmlData []byte) (Users, error) {
+	users, _, err := LoadUsers(bytes.NewReader(xmlData), LoadOptions{})
+	return users, err
+}
+
+func contains(arr [3]string, str string) bool {
+	for _, a := range arr {
+		if a == str {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeOrderField приводит order_field к одному из полей сортировки, пустое значение - сортировка по имени
+func normalizeOrderField(orderField string) (string, error) {
+	fields := [3]string{}
+	fields[0] = "name"
+	fields[1] = "id"
+	fields[2] = "age"
+
+	if contains(fields, strings.ToLower(orderField)) {
+		return strings.ToLower(orderField), nil
+	} else if orderField == "" {
+		return "name", nil
+	}
+	return "", errBadOrderField
+}
+
+// searchParams - разобранные параметры поискового запроса
+type searchParams struct {
+	Query      string
+	OrderField string
+	Limit      int
+	Offset     int
+	OrderBy    int
+}
+
+// request - параметры в виде запроса к UserStore
+func (params searchParams) request() SearchRequest {
+	return SearchRequest{
+		Query:      params.Query,
+		OrderField: params.OrderField,
+		Limit:      params.Limit,
+		Offset:     params.Offset,
+		OrderBy:    params.OrderBy,
+	}
+}
+
+// paramError - ошибка разбора конкретного параметра запроса
+type paramError struct {
+	Field string
+	Err   error
+}
+
+func (e *paramError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func parseIntParam(r *http.Request, name string, def int) (int, error) {
+	if r.FormValue(name) == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(r.FormValue(name))
+	if err != nil {
+		return def, &paramError{Field: name, Err: err}
+	}
+	return value, nil
+}
+
+// parseSearchParams разбирает параметры поиска из формы или JSON-тела и добавляет их в спан запроса
+func parseSearchParams(r *http.Request) (searchParams, error) {
+	parse := parseSearchForm
+	if isJSONBody(r) {
+		parse = parseSearchBody
+	}
+	params, err := parse(r)
+	if err == nil {
+		traceSearch(spanFromContext(r.Context()), params.request())
+	}
+	return params, err
+}
+
+func parseSearchForm(r *http.Request) (searchParams, error) {
+	params := searchParams{Query: r.FormValue("query")}
+
+	var err error
+	if params.OrderField, err = normalizeOrderField(r.FormValue("order_field")); err != nil {
+		return params, err
+	}
+	if params.Limit, err = parseIntParam(r, "limit", 10); err != nil {
+		return params, err
+	}
+	if params.Offset, err = parseIntParam(r, "offset", 0); err != nil {
+		return params, err
+	}
+	if params.OrderBy, err = parseIntParam(r, "order_by", 0); err != nil {
+		return params, err
+	}
+
+	return params, nil
+}
+
+func handleRequest(r *http.Request) ([]User, error) {
+	store, params, err := prepareRequest(r)
+
+	var userList []User
+
+	if err != nil {
+		return userList, err
+	}
+
+	return findUsers(store, params)
+}
+
+// prepareRequest открывает хранилище запроса и разбирает параметры поиска
+func prepareRequest(r *http.Request) (UserStore, searchParams, error) {
+	store, err := requestStore(r)
+	if err != nil {
+		return nil, searchParams{}, err
+	}
+	params, err := parseSearchParams(r)
+	return store, params, err
+}
+
+func findUsers(store UserStore, params searchParams) ([]User, error) {
+	rows, err := store.FindRows(params.request())
+	if err != nil {
+		return nil, err
+	}
+	return toUsers(rows), nil
+}
+
+/** эмулирует входящий запрос на сервер, который должен отдать соответствующий ответ*/
+func QueryDummy(w http.ResponseWriter, r *http.Request) {
+	queryV1(w, r, false)
+}
+
+// queryV1 - первая версия API; с validators ответ получает ETag и Cache-Control и может быть 304
+func queryV1(w http.ResponseWriter, r *http.Request, validators bool) {
+
+	StatusCode := 200
+	var result []User
+	var data []byte
+	var err error
+
+	if !authorized(r) {
+		StatusCode = http.StatusUnauthorized
+	} else {
+		var store UserStore
+		var params searchParams
+		store, params, err = prepareRequest(r)
+		if err == nil && validators && notModified(w, r, store, APIVersion1, params) {
+			return
+		}
+		if err == nil {
+			result, err = findUsers(store, params)
+		}
+
+		if err == nil {
+			data, _ = json.Marshal(result)
+		} else {
+			StatusCode = http.StatusBadRequest
+		}
+	}
+
+	//fmt.Println(result)
+	//fmt.Println(data)
+	switch StatusCode {
+	case http.StatusBadRequest:
+		if err == errBadOrderField {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"ErrorBadOrderField"}`))
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"status": 400, "err": "user_read_error"}`)
+		}
+	case http.StatusUnauthorized:
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"status": 401, "err": "bad_access_tocken"}`)
+	case 200:
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build sqlite
+
+package main
+
+// драйвер SQLite для StoreSQLite, нужен cgo: go get github.com/mattn/go-sqlite3
+import _ "github.com/mattn/go-sqlite3"
+
+func init() {
+	sqliteDriver = "sqlite3"
+}
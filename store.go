@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// UserStore - источник данных для поиска. HTTP- и gRPC-обработчики работают только через него,
+// поэтому датасет можно перенести из XML, не трогая ни их, ни SearchClient
+type UserStore interface {
+	// FindRows возвращает записи, где req.Query встречается в имени или About, отсортированные по
+	// req.OrderField и req.OrderBy, начиная с req.Offset. Limit < 0 - без ограничения
+	FindRows(req SearchRequest) ([]UserXml, error)
+	// CountUsers возвращает количество записей, подходящих под query
+	CountUsers(query string) (int, error)
+}
+
+// rowIterator - хранилище, которое отдаёт записи по одной, не собирая их в срез; через него идут выгрузки
+type rowIterator interface {
+	// EachRow передаёт в fn по одной записи, которые вернул бы FindRows, и прекращается
+	// на первой ошибке fn или с отменой ctx
+	EachRow(ctx context.Context, req SearchRequest, fn func(UserXml) error) error
+}
+
+// eachRow обходит записи store по одной. Хранилище без rowIterator сначала отдаёт их срезом FindRows
+func eachRow(ctx context.Context, store UserStore, req SearchRequest, fn func(UserXml) error) error {
+	if iterator, ok := store.(rowIterator); ok {
+		return iterator.EachRow(ctx, req, fn)
+	}
+	rows, err := store.FindRows(req)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryStore - хранилище поверх загруженного в память датасета. FindRows сортирует Users.List на месте,
+// поэтому один MemoryStore нельзя использовать из нескольких запросов сразу - для этого есть IndexedStore
+type MemoryStore struct {
+	Users Users
+
+	version DatasetVersion
+}
+
+var _ UserStore = (*MemoryStore)(nil)
+
+func (m *MemoryStore) FindRows(req SearchRequest) ([]UserXml, error) {
+	return m.Users.FindRows(req.Query, req.OrderField, req.Limit, req.Offset, req.OrderBy), nil
+}
+
+func (m *MemoryStore) CountUsers(query string) (int, error) {
+	return m.Users.CountUsers(query), nil
+}
+
+// IndexedStore - хранилище в памяти с заранее отсортированными индексами по каждому order_field.
+// Датасет не меняется после создания, поэтому IndexedStore можно делить между запросами
+type IndexedStore struct {
+	users Users
+	// позиции записей по возрастанию и по убыванию поля
+	asc, desc map[string][]int
+
+	version DatasetVersion
+}
+
+var _ UserStore = (*IndexedStore)(nil)
+var _ rowIterator = (*IndexedStore)(nil)
+
+// NewIndexedStore строит индексы сортировки; при равных значениях поля сохраняется порядок датасета.
+// Если датасет загружен с LoadOptions.Index, берутся индексы, построенные при загрузке
+func NewIndexedStore(users Users) *IndexedStore {
+	index := users.index
+	if index == nil {
+		builder := newIndexBuilder()
+		for _, row := range users.List {
+			builder.add(row)
+		}
+		index = builder.build()
+	}
+	users.index = nil
+	return &IndexedStore{users: users, asc: index.asc, desc: index.desc}
+}
+
+func (s *IndexedStore) FindRows(req SearchRequest) ([]UserXml, error) {
+	var rowList []UserXml
+	err := s.EachRow(context.Background(), req, func(row UserXml) error {
+		rowList = append(rowList, row)
+		return nil
+	})
+	return rowList, err
+}
+
+func (s *IndexedStore) EachRow(ctx context.Context, req SearchRequest, fn func(UserXml) error) error {
+	order := s.asc[req.OrderField]
+	if req.OrderBy == OrderByDesc {
+		order = s.desc[req.OrderField]
+	}
+	if req.OrderBy == OrderByAsIs || order == nil {
+		order = nil
+	}
+
+	sent, skipped := 0, 0
+	for i := range s.users.List {
+		if sent == req.Limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row := s.users.List[i]
+		if order != nil {
+			row = s.users.List[order[i]]
+		}
+		if !row.Matches(req.Query) {
+			continue
+		}
+		if skipped < req.Offset {
+			skipped++
+			continue
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+
+func (s *IndexedStore) CountUsers(query string) (int, error) {
+	return s.users.CountUsers(query), nil
+}
+
+// Len возвращает количество записей в датасете
+func (s *IndexedStore) Len() int {
+	return len(s.users.List)
+}
+
+// виды хранилищ
+const (
+	StoreXML    = "xml"
+	StoreJSON   = "json"
+	StoreCSV    = "csv"
+	StoreSQLite = "sqlite"
+)
+
+// StoreConfig - откуда SearchServer берёт пользователей
+type StoreConfig struct {
+	// StoreXML, StoreJSON, StoreCSV или StoreSQLite, пусто - StoreXML
+	Kind string `json:"kind"`
+	// файл датасета или базы SQLite, для XML по умолчанию datasetPath
+	Path string `json:"path"`
+}
+
+// serverStore - хранилище SearchServer, задаётся флагами команды serve
+var serverStore StoreConfig
+
+// sqliteDriver - имя драйвера database/sql для SQLite, его регистрирует сборка с тегом sqlite
+var sqliteDriver string
+
+var (
+	sqlDBsMu sync.Mutex
+	sqlDBs   = map[string]*sql.DB{}
+)
+
+// Open открывает хранилище. Файловые датасеты читаются заново при каждом открытии, поэтому SearchServer
+// держит загруженный датасет в openServerStore. Подключение к базе SQLite открывается один раз на файл
+func (c StoreConfig) Open() (UserStore, error) {
+	switch c.Kind {
+	case "", StoreXML:
+		path := c.Path
+		if path == "" {
+			path = datasetPath
+		}
+		return loadFileStore(path, LoadUsers)
+	case StoreJSON, StoreCSV:
+		if c.Path == "" {
+			return nil, fmt.Errorf("%s store needs a dataset path", c.Kind)
+		}
+		load := LoadUsersJSON
+		if c.Kind == StoreCSV {
+			load = LoadUsersCSV
+		}
+		return loadFileStore(c.Path, load)
+	case StoreSQLite:
+		db, err := openSQLite(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &SQLStore{DB: db}, nil
+	}
+	return nil, fmt.Errorf("unknown store %s", c.Kind)
+}
+
+// loadFileStore загружает файловый датасет и ставит ему версию: хэш прочитанного содержимого и время загрузки.
+// Хэш считается по ходу чтения, поэтому копия с сохранёнными временами или перезапись того же размера
+// получают новый ETag, если поменялись данные
+func loadFileStore(path string, load func(io.Reader, LoadOptions) (Users, LoadProgress, error)) (UserStore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	users, _, err := load(io.TeeReader(file, hash), LoadOptions{RowError: rowErrorLog(path), Index: true})
+	if err != nil {
+		return &MemoryStore{Users: users}, err
+	}
+	// загрузчик может не дочитать хвост файла после последней записи
+	if _, err := io.Copy(hash, file); err != nil {
+		return &MemoryStore{Users: users}, err
+	}
+	return &MemoryStore{Users: users, version: stampVersion(path, hex.EncodeToString(hash.Sum(nil)[:16]))}, nil
+}
+
+var (
+	versionsMu sync.Mutex
+	// последняя загруженная версия каждого файла
+	loadedVersions = map[string]DatasetVersion{}
+)
+
+// stampVersion ставит версию загруженному содержимому файла. То же содержимое сохраняет прежнюю версию
+// вместе с Last-Modified, а новое получает время загрузки, но не раньше следующей секунды после прежнего:
+// Last-Modified передаётся с точностью до секунды, и перезагрузка в ту же секунду давала бы ложный 304
+func stampVersion(path, tag string) DatasetVersion {
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+	previous, ok := loadedVersions[path]
+	if ok && previous.Tag == tag {
+		return previous
+	}
+	modified := time.Now()
+	if ok && !modified.Truncate(time.Second).After(previous.Modified.Truncate(time.Second)) {
+		modified = previous.Modified.Truncate(time.Second).Add(time.Second)
+	}
+	version := DatasetVersion{Tag: tag, Modified: modified}
+	loadedVersions[path] = version
+	return version
+}
+
+// sharedStore - хранилище SearchServer без арендаторов. Файловый датасет загружается один раз на версию файла
+// в IndexedStore, и его делят HTTP-запросы, gRPC и метрики; поменялся файл - загружается заново
+type sharedStore struct {
+	mu       sync.Mutex
+	config   StoreConfig
+	store    UserStore
+	file     fileStamp
+	loadedAt time.Time
+}
+
+var serverShared sharedStore
+
+// openServerStore возвращает хранилище serverStore и время загрузки его датасета
+func openServerStore() (UserStore, time.Time, error) {
+	config := serverStore
+	if config.Kind == StoreSQLite {
+		// база открывается один раз, а данные в ней меняются в обход файла - загружать нечего
+		store, err := config.Open()
+		return store, time.Time{}, err
+	}
+	if config.Path == "" {
+		config.Path = datasetPath
+	}
+
+	serverShared.mu.Lock()
+	defer serverShared.mu.Unlock()
+	current := stampFile(config.Path)
+	if serverShared.store != nil && serverShared.config == config && current != "" && current == serverShared.file {
+		return serverShared.store, serverShared.loadedAt, nil
+	}
+	// пока датасет загружается, остальные запросы ждут его, а не грузят свою копию
+	store, err := config.Open()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	store = indexed(store)
+	serverShared.config, serverShared.store, serverShared.file, serverShared.loadedAt = config, store, current, time.Now()
+	return store, serverShared.loadedAt, nil
+}
+
+// indexed заменяет MemoryStore на IndexedStore, который можно делить между запросами
+func indexed(store UserStore) UserStore {
+	memory, ok := store.(*MemoryStore)
+	if !ok {
+		return store
+	}
+	result := NewIndexedStore(memory.Users)
+	result.version = memory.version
+	return result
+}
+
+// DatasetVersion - версия загруженного датасета, из неё сервер строит ETag и Last-Modified
+type DatasetVersion struct {
+	// хэш содержимого файла датасета; пустой - версия неизвестна
+	Tag string
+	// время загрузки датасета
+	Modified time.Time
+}
+
+// versionedStore - хранилище, которое знает версию своих данных. SQLStore её не знает:
+// база может меняться в обход файла, поэтому его ответы отдаются без ETag
+type versionedStore interface {
+	Version() DatasetVersion
+}
+
+func (m *MemoryStore) Version() DatasetVersion { return m.version }
+
+func (s *IndexedStore) Version() DatasetVersion { return s.version }
+
+// fileStamp - подпись файла по времени изменения, размеру и, где оно есть, времени смены inode. По ней сервер
+// дёшево проверяет, не пора ли загрузить датасет заново; версию загруженных данных она не заменяет
+type fileStamp string
+
+// fileChangeTime возвращает ctime файла: его меняет любая запись, и его не сохранить копированием
+// с сохранением времён. Задаётся для linux в filestamp_linux.go, на остальных системах - 0
+var fileChangeTime = func(os.FileInfo) int64 { return 0 }
+
+// stampFile снимает подпись файла до загрузки: если он поменяется во время чтения,
+// следующий запрос загрузит его заново. Пустая подпись - файла нет
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fileStamp(fmt.Sprintf("%x-%x-%x", info.ModTime().UnixNano(), info.Size(), fileChangeTime(info)))
+}
+
+var (
+	rowLogMu sync.Mutex
+	// подписи файлов, битые строки которых уже попали в лог
+	rowLogVersions = map[string]fileStamp{}
+)
+
+// rowErrorLog пишет битые строки файла path в лог только при первой загрузке его версии:
+// датасет перезагружают Reload и SIGHUP, и без этого одни и те же строки попадали бы в лог при каждой загрузке
+func rowErrorLog(path string) func(RowError) {
+	stamp := stampFile(path)
+	rowLogMu.Lock()
+	defer rowLogMu.Unlock()
+	if stamp != "" && rowLogVersions[path] == stamp {
+		return func(RowError) {}
+	}
+	rowLogVersions[path] = stamp
+	return logRowError
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	if sqliteDriver == "" {
+		return nil, errors.New("sqlite store is not compiled in, build with -tags sqlite")
+	}
+	if path == "" {
+		return nil, errors.New("sqlite store needs a database path")
+	}
+	sqlDBsMu.Lock()
+	defer sqlDBsMu.Unlock()
+	if db, ok := sqlDBs[path]; ok {
+		return db, nil
+	}
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	sqlDBs[path] = db
+	return db, nil
+}
+
+// searchPage возвращает страницу результатов и признак того, что за ней есть ещё записи
+func searchPage(store UserStore, params searchParams) ([]User, bool, error) {
+	// запрашиваем на одну запись больше, чтобы понять, есть ли следующая страница
+	req := params.request()
+	req.Limit++
+	rows, err := store.FindRows(req)
+	if err != nil {
+		return nil, false, err
+	}
+	page := toUsers(rows)
+	if len(page) > params.Limit {
+		return page[:params.Limit], true, nil
+	}
+	return page, false, nil
+}
+
+// toUsers переводит записи датасета в ответ поиска; для пустого результата возвращает nil, как Users.FindUsers
+func toUsers(rows []UserXml) []User {
+	var userList []User
+	for _, row := range rows {
+		userList = append(userList, row.User())
+	}
+	return userList
+}
+
+// LoadUsersJSON читает датасет из JSON-массива объектов с теми же именами полей, что в dataset.xml.
+// Записи с неподходящими типами значений пропускаются, синтаксическая ошибка прерывает загрузку
+func LoadUsersJSON(r io.Reader, opts LoadOptions) (Users, LoadProgress, error) {
+	loader := newRowLoader(opts)
+	decoder := json.NewDecoder(r)
+	token, err := decoder.Token()
+	if err != nil {
+		return loader.fail(decoder.InputOffset(), err)
+	}
+	if token != json.Delim('[') {
+		return loader.fail(decoder.InputOffset(), errors.New("json dataset must be an array"))
+	}
+
+	for decoder.More() {
+		row := UserXml{}
+		err := decoder.Decode(&row)
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			loader.add(row, &RowError{Field: typeErr.Field, Err: fmt.Errorf("cannot use %s as %s", typeErr.Value, typeErr.Type)}, 0, decoder.InputOffset())
+			continue
+		}
+		if err != nil {
+			return loader.fail(decoder.InputOffset(), err)
+		}
+		loader.add(row, nil, 0, decoder.InputOffset())
+	}
+	if _, err := decoder.Token(); err != nil {
+		return loader.fail(decoder.InputOffset(), err)
+	}
+	return loader.done(decoder.InputOffset())
+}
+
+// LoadUsersCSV читает датасет из CSV, первая строка - имена колонок как в dataset.xml, порядок любой.
+// Строки с неразбираемыми значениями или другим числом колонок пропускаются
+func LoadUsersCSV(r io.Reader, opts LoadOptions) (Users, LoadProgress, error) {
+	loader := newRowLoader(opts)
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return loader.fail(reader.InputOffset(), err)
+	}
+	for _, column := range header {
+		if _, ok := csvColumns[column]; !ok {
+			return loader.fail(reader.InputOffset(), fmt.Errorf("unknown column %s", column))
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if parseErr, ok := err.(*csv.ParseError); ok && parseErr.Err == csv.ErrFieldCount {
+			loader.add(UserXml{}, &RowError{Field: "record", Err: parseErr.Err}, parseErr.StartLine, reader.InputOffset())
+			continue
+		}
+		if err != nil {
+			return loader.fail(reader.InputOffset(), err)
+		}
+
+		raw := rawRow{}
+		for i, column := range header {
+			csvColumns[column](&raw, record[i])
+		}
+		row, rowErr := raw.userXml()
+		loader.add(row, rowErr, line, reader.InputOffset())
+	}
+	return loader.done(reader.InputOffset())
+}
+
+// csvColumns - колонки CSV-датасета, совпадают с элементами строки dataset.xml
+var csvColumns = map[string]func(*rawRow, string){
+	"id":         func(r *rawRow, v string) { r.ID = v },
+	"guid":       func(r *rawRow, v string) { r.GUID = v },
+	"isActive":   func(r *rawRow, v string) { r.Active = v },
+	"balance":    func(r *rawRow, v string) { r.Balance = v },
+	"picture":    func(r *rawRow, v string) { r.Picture = v },
+	"age":        func(r *rawRow, v string) { r.Age = v },
+	"eyeColor":   func(r *rawRow, v string) { r.EyeColor = v },
+	"first_name": func(r *rawRow, v string) { r.FirstName = v },
+	"last_name":  func(r *rawRow, v string) { r.LastName = v },
+	"gender":     func(r *rawRow, v string) { r.Gender = v },
+	"company":    func(r *rawRow, v string) { r.Company = v },
+	"email":      func(r *rawRow, v string) { r.Email = v },
+	"phone":      func(r *rawRow, v string) { r.Phone = v },
+	"address":    func(r *rawRow, v string) { r.Address = v },
+	"about":      func(r *rawRow, v string) { r.About = v },
+}
+
+// WriteUsersJSON пишет датасет в формате, который читает LoadUsersJSON
+func WriteUsersJSON(w io.Writer, rows []UserXml) error {
+	if rows == nil {
+		rows = []UserXml{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+// WriteUsersCSV пишет датасет в формате, который читает LoadUsersCSV
+func WriteUsersCSV(w io.Writer, rows []UserXml) error {
+	return encodeCSV(w, rows, defaultXMLColumns)
+}
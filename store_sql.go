@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SQLStore ищет пользователей в таблице базы SQLite: фильтр по query и сортировка по order_field
+// переводятся в SQL, в память датасет не загружается
+type SQLStore struct {
+	DB *sql.DB
+	// таблица, созданная WriteSQL, по умолчанию users
+	Table string
+}
+
+var _ UserStore = (*SQLStore)(nil)
+var _ rowIterator = (*SQLStore)(nil)
+
+const defaultSQLTable = "users"
+
+// колонки таблицы в порядке полей UserXml; position хранит исходный порядок записей
+const sqlColumns = "id, guid, is_active, balance, picture, age, eye_color, first_name, last_name, gender, company, email, phone, address, about"
+
+// sqlWhere повторяет UserXml.Matches: подстрока в "first_name last_name" или в about, с учётом регистра
+const sqlWhere = "(? = '' OR instr(first_name || ' ' || last_name, ?) > 0 OR instr(about, ?) > 0)"
+
+// sqlOrderFields - выражения для order_field, значения которого уже приведены normalizeOrderField
+var sqlOrderFields = map[string]string{
+	"id":   "id",
+	"age":  "age",
+	"name": "first_name || ' ' || last_name",
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *SQLStore) table() (string, error) {
+	table := s.Table
+	if table == "" {
+		table = defaultSQLTable
+	}
+	if !sqlIdentifier.MatchString(table) {
+		return "", fmt.Errorf("bad table name %q", table)
+	}
+	return table, nil
+}
+
+func (s *SQLStore) FindRows(req SearchRequest) ([]UserXml, error) {
+	result := []UserXml{}
+	err := s.EachRow(context.Background(), req, func(row UserXml) error {
+		result = append(result, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *SQLStore) EachRow(ctx context.Context, req SearchRequest, fn func(UserXml) error) error {
+	table, err := s.table()
+	if err != nil {
+		return err
+	}
+
+	// как и в Users.FindRows, 1 - по убыванию, -1 - по возрастанию, 0 и неизвестное поле - как в датасете
+	order := "position"
+	if field, ok := sqlOrderFields[req.OrderField]; ok && req.OrderBy != OrderByAsIs {
+		direction := "ASC"
+		if req.OrderBy == OrderByDesc {
+			direction = "DESC"
+		}
+		order = field + " " + direction + ", position"
+	}
+
+	query := "SELECT " + sqlColumns + " FROM " + table + " WHERE " + sqlWhere + " ORDER BY " + order + " LIMIT ? OFFSET ?"
+	rows, err := s.DB.QueryContext(ctx, query, req.Query, req.Query, req.Query, req.Limit, req.Offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := UserXml{}
+		err := rows.Scan(&row.ID, &row.GUID, &row.Active, &row.Balance, &row.Picture, &row.Age, &row.EyeColor,
+			&row.FirstName, &row.LastName, &row.Gender, &row.Company, &row.Email, &row.Phone, &row.Address, &row.About)
+		if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) CountUsers(query string) (int, error) {
+	table, err := s.table()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	err = s.DB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+sqlWhere, query, query, query).Scan(&total)
+	return total, err
+}
+
+// WriteSQL создаёт таблицу для SQLStore и записывает в неё датасет одной транзакцией.
+// Существующая таблица с тем же именем заменяется
+func WriteSQL(db *sql.DB, table string, rows []UserXml) error {
+	if table == "" {
+		table = defaultSQLTable
+	}
+	if !sqlIdentifier.MatchString(table) {
+		return fmt.Errorf("bad table name %q", table)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DROP TABLE IF EXISTS " + table,
+		"CREATE TABLE " + table + ` (
+			position INTEGER NOT NULL PRIMARY KEY,
+			id INTEGER NOT NULL, guid TEXT NOT NULL, is_active BOOLEAN NOT NULL, balance TEXT NOT NULL,
+			picture TEXT NOT NULL, age INTEGER NOT NULL, eye_color TEXT NOT NULL, first_name TEXT NOT NULL,
+			last_name TEXT NOT NULL, gender TEXT NOT NULL, company TEXT NOT NULL, email TEXT NOT NULL,
+			phone TEXT NOT NULL, address TEXT NOT NULL, about TEXT NOT NULL)`,
+		"CREATE INDEX " + table + "_id ON " + table + " (id)",
+		"CREATE INDEX " + table + "_age ON " + table + " (age)",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	insert, err := tx.Prepare("INSERT INTO " + table + " (position, " + sqlColumns + ") VALUES (?" + strings.Repeat(", ?", 15) + ")")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for i, row := range rows {
+		_, err := insert.Exec(i, row.ID, row.GUID, row.Active, row.Balance, row.Picture, row.Age, row.EyeColor,
+			row.FirstName, row.LastName, row.Gender, row.Company, row.Email, row.Phone, row.Address, row.About)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,78 @@
+//go:build sqlite
+
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSQLiteStore(t *testing.T) string {
+	users, err := loadUsersFrom("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant load dataset: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := openSQLite(path)
+	if err != nil {
+		t.Fatalf("cant open sqlite: %s", err)
+	}
+	if err := WriteSQL(db, "", users.List); err != nil {
+		t.Fatalf("cant write sqlite: %s", err)
+	}
+	return path
+}
+
+func TestSQLStoreMatchesMemoryStore(t *testing.T) {
+	store, err := StoreConfig{Kind: StoreSQLite, Path: writeSQLiteStore(t)}.Open()
+	if err != nil {
+		t.Fatalf("cant open store: %s", err)
+	}
+
+	for _, query := range []string{"", "Boyd", "Nulla", "boyd", "nobody"} {
+		for _, field := range []string{"id", "name"} {
+			for _, orderBy := range []int{OrderByAsc, OrderByAsIs, OrderByDesc} {
+				for _, page := range [][2]int{{-1, 0}, {5, 0}, {5, 30}, {0, 0}} {
+					req := SearchRequest{Query: query, OrderField: field, OrderBy: orderBy, Limit: page[0], Offset: page[1]}
+					users, _ := loadUsersFrom("./dataset.xml")
+					expected, _ := (&MemoryStore{Users: users}).FindRows(req)
+					rows, err := store.FindRows(req)
+					if err != nil {
+						t.Fatalf("%+v: unexpected error: %s", req, err)
+					}
+					if len(expected) == 0 && len(rows) == 0 {
+						continue
+					}
+					if !reflect.DeepEqual(rows, expected) {
+						t.Errorf("%+v: sqlite rows differ from memory store", req)
+					}
+				}
+			}
+		}
+		users, _ := loadUsersFrom("./dataset.xml")
+		total, err := store.CountUsers(query)
+		if err != nil || total != users.CountUsers(query) {
+			t.Errorf("%q: expected %d users, got %d %v", query, users.CountUsers(query), total, err)
+		}
+	}
+
+	if _, err := (&SQLStore{DB: store.(*SQLStore).DB, Table: "users; DROP TABLE users"}).CountUsers(""); err == nil {
+		t.Errorf("expected error for bad table name")
+	}
+}
+
+func TestSearchServerSQLiteConformance(t *testing.T) {
+	useStore(t, StoreConfig{Kind: StoreSQLite, Path: writeSQLiteStore(t)})
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		t.Run(version, func(t *testing.T) {
+			ConformanceSuite{
+				Handler:     http.HandlerFunc(SearchServer),
+				Dataset:     "./dataset.xml",
+				AccessToken: serverAccessToken,
+				Version:     version,
+			}.Run(t)
+		})
+	}
+}
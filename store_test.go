@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeStore переносит dataset.xml в файл хранилища kind и возвращает путь к нему
+func writeStore(t *testing.T, kind string) string {
+	users, err := loadUsersFrom("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant load dataset: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "users."+kind)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("cant create %s: %s", path, err)
+	}
+	defer file.Close()
+	write := WriteUsersJSON
+	if kind == StoreCSV {
+		write = WriteUsersCSV
+	}
+	if err := write(file, users.List); err != nil {
+		t.Fatalf("cant write %s: %s", kind, err)
+	}
+	return path
+}
+
+// useStore переключает SearchServer на хранилище config до конца теста
+func useStore(t *testing.T, config StoreConfig) {
+	previous := serverStore
+	serverStore = config
+	t.Cleanup(func() { serverStore = previous })
+}
+
+func TestFileStoresRoundTrip(t *testing.T) {
+	expected, err := loadUsersFrom("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant load dataset: %s", err)
+	}
+	for _, kind := range []string{StoreJSON, StoreCSV} {
+		store, err := StoreConfig{Kind: kind, Path: writeStore(t, kind)}.Open()
+		if err != nil {
+			t.Fatalf("[%s] cant open store: %s", kind, err)
+		}
+		rows, err := store.FindRows(SearchRequest{Limit: -1})
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %s", kind, err)
+		}
+		if !reflect.DeepEqual(rows, expected.List) {
+			t.Errorf("[%s] rows differ from dataset.xml", kind)
+		}
+	}
+}
+
+func TestSearchServerStoresConformance(t *testing.T) {
+	for _, kind := range []string{StoreJSON, StoreCSV} {
+		t.Run(kind, func(t *testing.T) {
+			useStore(t, StoreConfig{Kind: kind, Path: writeStore(t, kind)})
+			for _, version := range []string{APIVersion1, APIVersion2} {
+				t.Run(version, func(t *testing.T) {
+					ConformanceSuite{
+						Handler:     http.HandlerFunc(SearchServer),
+						Dataset:     "./dataset.xml",
+						AccessToken: serverAccessToken,
+						Version:     version,
+					}.Run(t)
+				})
+			}
+		})
+	}
+}
+
+func TestLoadUsersJSONSkipsMalformedRows(t *testing.T) {
+	data := `[
+		{"id": 1, "first_name": "Boyd", "age": 22},
+		{"id": 2, "first_name": "Hilda", "age": "old"},
+		{"id": 3, "first_name": "Brooks", "isActive": true}
+	]`
+	var rowErrors []RowError
+	users, progress, err := LoadUsersJSON(strings.NewReader(data), LoadOptions{
+		RowError: func(err RowError) { rowErrors = append(rowErrors, err) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(users.List) != 2 || users.List[1].FirstName != "Brooks" || !users.List[1].Active || progress.Skipped != 1 {
+		t.Errorf("unexpected rows %+v %+v", users.List, progress)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Row != 2 || rowErrors[0].Field != "age" || !strings.HasPrefix(rowErrors[0].Error(), "row 2: age: ") {
+		t.Errorf("unexpected row errors %v", rowErrors)
+	}
+
+	if _, _, err := LoadUsersJSON(strings.NewReader(`{"id": 1}`), LoadOptions{}); err == nil {
+		t.Errorf("expected error for non-array json")
+	}
+	if _, _, err := LoadUsersJSON(strings.NewReader(`[{"id": 1}, {"id"`), LoadOptions{}); err == nil {
+		t.Errorf("expected error for broken json")
+	}
+}
+
+func TestLoadUsersCSVSkipsMalformedRows(t *testing.T) {
+	data := "age,first_name,id\n22,Boyd,1\nold,Hilda,2\n30,Brooks\n40,\"Ann\nMarie\",4\n"
+	var rowErrors []RowError
+	users, _, err := LoadUsersCSV(strings.NewReader(data), LoadOptions{
+		RowError: func(err RowError) { rowErrors = append(rowErrors, err) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(users.List) != 2 || users.List[0].Age != 22 || users.List[1].FirstName != "Ann\nMarie" || users.List[1].ID != 4 {
+		t.Errorf("unexpected rows %+v", users.List)
+	}
+	if len(rowErrors) != 2 || rowErrors[0].Line != 3 || rowErrors[0].Field != "age" || rowErrors[1].Line != 4 || rowErrors[1].Field != "record" {
+		t.Errorf("unexpected row errors %v", rowErrors)
+	}
+
+	if _, _, err := LoadUsersCSV(strings.NewReader("id,salary\n1,100\n"), LoadOptions{}); err == nil || !strings.Contains(err.Error(), "salary") {
+		t.Errorf("expected unknown column error, got %v", err)
+	}
+}
+
+func TestStoreLogsBadRowsOncePerVersion(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	path := filepath.Join(t.TempDir(), "users.csv")
+	if err := ioutil.WriteFile(path, []byte("age,id\nold,1\n22,2\n"), 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+	config := StoreConfig{Kind: StoreCSV, Path: path}
+	for i := 0; i < 3; i++ {
+		if _, err := config.Open(); err != nil {
+			t.Fatalf("cant open store: %s", err)
+		}
+	}
+	if lines := strings.Count(logged.String(), "skip row"); lines != 1 {
+		t.Errorf("expected bad row logged once for one file version, got %d:\n%s", lines, logged.String())
+	}
+
+	if err := ioutil.WriteFile(path, []byte("age,id\nold,1\nyoung,2\n"), 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+	logged.Reset()
+	config.Open()
+	config.Open()
+	if lines := strings.Count(logged.String(), "skip row"); lines != 2 {
+		t.Errorf("expected both bad rows of the new version logged once, got %d:\n%s", lines, logged.String())
+	}
+}
+
+func TestStoreConfigOpenErrors(t *testing.T) {
+	cases := []StoreConfig{
+		{Kind: "mongo"},
+		{Kind: StoreJSON},
+		{Kind: StoreCSV, Path: "./missing.csv"},
+	}
+	if sqliteDriver == "" {
+		cases = append(cases, StoreConfig{Kind: StoreSQLite, Path: "users.db"})
+	}
+	for _, config := range cases {
+		if _, err := config.Open(); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
+
+func TestSearchServerStoreUnavailable(t *testing.T) {
+	useStore(t, StoreConfig{Kind: StoreJSON, Path: "./missing.json"})
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+	s := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL, Version: APIVersion2}
+
+	if _, err := s.FindUsers(SearchRequest{Limit: 1}); err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("expected fatal error, got %v", err)
+	}
+}
+
+func TestConvertDatasetCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "users.csv")
+	if err := convertDatasetCommand([]string{"-to", StoreCSV, "-out", out}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("cant read result: %s", err)
+	}
+	if !bytes.HasPrefix(data, []byte("id,guid,isActive,")) {
+		t.Errorf("unexpected csv header %q", data[:40])
+	}
+
+	if err := convertDatasetCommand([]string{"-to", "parquet", "-out", out}); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if err := convertDatasetCommand([]string{"-to", StoreJSON}); err == nil {
+		t.Errorf("expected error without -out")
+	}
+}
+
+func TestIndexedStoreMatchesMemoryStore(t *testing.T) {
+	users, err := loadUsersFrom("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant load dataset: %s", err)
+	}
+	indexed := NewIndexedStore(users)
+	if indexed.Len() != len(users.List) {
+		t.Fatalf("expected %d rows, got %d", len(users.List), indexed.Len())
+	}
+
+	for _, query := range []string{"", "Boyd", "Nulla", "nobody"} {
+		for _, field := range []string{"id", "age", "name", "unknown"} {
+			for _, orderBy := range []int{OrderByAsc, OrderByAsIs, OrderByDesc} {
+				for _, page := range [][2]int{{-1, 0}, {5, 0}, {5, 12}, {0, 0}} {
+					req := SearchRequest{Query: query, OrderField: field, OrderBy: orderBy, Limit: page[0], Offset: page[1]}
+					fresh, _ := loadUsersFrom("./dataset.xml")
+					expected, _ := (&MemoryStore{Users: fresh}).FindRows(req)
+					rows, _ := indexed.FindRows(req)
+					if len(rows) != len(expected) {
+						t.Fatalf("%+v: expected %d rows, got %d", req, len(expected), len(rows))
+					}
+					// при равных значениях поля порядок не определён, сравниваем только ключи сортировки
+					for i := range rows {
+						if field == "age" && rows[i].Age != expected[i].Age || field != "age" && rows[i].ID != expected[i].ID {
+							t.Fatalf("%+v: row %d differs: %+v vs %+v", req, i, rows[i], expected[i])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestIndexedStoreEachRowStopsOnCancel(t *testing.T) {
+	users, err := loadUsersFrom("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant load dataset: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ids []int
+	err = NewIndexedStore(users).EachRow(ctx, SearchRequest{OrderField: "id", OrderBy: OrderByDesc, Limit: -1}, func(row UserXml) error {
+		ids = append(ids, row.ID)
+		if len(ids) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || !reflect.DeepEqual(ids, []int{34, 33, 32}) {
+		t.Errorf("expected 3 rows before cancel, got %v %v", ids, err)
+	}
+}
+
+func TestServerStoreLoadedOncePerVersion(t *testing.T) {
+	data, err := ioutil.ReadFile("./dataset.xml")
+	if err != nil {
+		t.Fatalf("cant read dataset: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+	useStore(t, StoreConfig{Kind: StoreXML, Path: path})
+
+	first, loadedAt, err := openServerStore()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := first.(*IndexedStore); !ok {
+		t.Errorf("expected shared IndexedStore, got %T", first)
+	}
+	second, _, _ := openServerStore()
+	if second != first {
+		t.Errorf("expected the same store while the file is unchanged")
+	}
+	if datasets := serverDatasets(); len(datasets) != 1 || datasets[0].Rows != 35 || !datasets[0].LoadedAt.Equal(loadedAt) {
+		t.Errorf("metrics must report the shared store and its load time, got %+v", datasets)
+	}
+
+	// другой размер - другая версия файла
+	shorter := strings.Replace(string(data), "<row>", "<skipped>", 1)
+	shorter = strings.Replace(shorter, "</row>", "</skipped>", 1)
+	if err := ioutil.WriteFile(path, []byte(shorter+"\n"), 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+	third, reloadedAt, err := openServerStore()
+	if err != nil || third == first || reloadedAt.Before(loadedAt) {
+		t.Fatalf("expected reload after the file changed, got %v", err)
+	}
+	if rows, _ := third.CountUsers(""); rows != 34 {
+		t.Errorf("expected reloaded rows, got %d", rows)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// префикс пути, по которому TenantServer выбирает арендатора: /tenants/{name}/...
+	tenantPathPrefix = "/tenants/"
+
+	ErrorCodeUnknownTenant   = "ErrorUnknownTenant"
+	ErrorCodeTooManyRequests = "ErrorTooManyRequests"
+)
+
+// Tenant - отдельный датасет на общем сервере со своими токенами и ограничениями
+type Tenant struct {
+	Name  string      `json:"name"`
+	Store StoreConfig `json:"store"`
+	// токены, с которыми принимаются запросы к этому датасету; по ним же выбирается арендатор без префикса пути
+	Tokens []string `json:"tokens"`
+	// сколько записей может быть в датасете, 0 - без ограничения
+	MaxRows int `json:"max_rows,omitempty"`
+	// сколько запросов обрабатывается одновременно, остальные получают 429; 0 - без ограничения
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+}
+
+// TenantServer - SearchServer для многих датасетов. Арендатор выбирается по префиксу пути /tenants/{name},
+// а если его нет - по токену из заголовка AccessToken. Дальше запрос обрабатывает SearchServer
+// с хранилищем и токенами этого арендатора
+type TenantServer struct {
+	mu      sync.RWMutex
+	tenants map[string]*tenantState
+	byToken map[string]*tenantState
+}
+
+type tenantState struct {
+	config   Tenant
+	inFlight chan struct{}
+
+	mu       sync.RWMutex
+	store    UserStore
+	loadedAt time.Time
+}
+
+type tenantContextKey struct{}
+
+// NewTenantServer загружает датасеты всех арендаторов. Имена и токены не должны повторяться
+func NewTenantServer(tenants []Tenant) (*TenantServer, error) {
+	server := &TenantServer{tenants: map[string]*tenantState{}, byToken: map[string]*tenantState{}}
+	for _, config := range tenants {
+		if config.Name == "" || strings.Contains(config.Name, "/") {
+			return nil, fmt.Errorf("bad tenant name %q", config.Name)
+		}
+		if _, ok := server.tenants[config.Name]; ok {
+			return nil, fmt.Errorf("tenant %s is configured twice", config.Name)
+		}
+		state := &tenantState{config: config}
+		if config.MaxInFlight > 0 {
+			state.inFlight = make(chan struct{}, config.MaxInFlight)
+		}
+		for _, token := range config.Tokens {
+			if other, ok := server.byToken[token]; ok {
+				return nil, fmt.Errorf("tenants %s and %s share a token", other.config.Name, config.Name)
+			}
+			server.byToken[token] = state
+		}
+		if err := state.reload(); err != nil {
+			return nil, fmt.Errorf("tenant %s: %s", config.Name, err)
+		}
+		server.tenants[config.Name] = state
+	}
+	return server, nil
+}
+
+// Reload перечитывает датасет арендатора. Если загрузка не удалась, арендатор продолжает работать на старых данных
+func (s *TenantServer) Reload(name string) error {
+	s.mu.RLock()
+	state, ok := s.tenants[name]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown tenant %s", name)
+	}
+	return state.reload()
+}
+
+// ReloadAll перечитывает датасеты всех арендаторов независимо друг от друга и возвращает ошибки по именам
+func (s *TenantServer) ReloadAll() map[string]error {
+	errs := map[string]error{}
+	for _, name := range s.Tenants() {
+		if err := s.Reload(name); err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+// Tenants возвращает имена арендаторов по алфавиту
+func (s *TenantServer) Tenants() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.tenants))
+	for name := range s.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadedAt возвращает время последней успешной загрузки датасета арендатора
+func (s *TenantServer) LoadedAt(name string) (time.Time, bool) {
+	s.mu.RLock()
+	state, ok := s.tenants[name]
+	s.mu.RUnlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.loadedAt, true
+}
+
+// Datasets возвращает размер и время загрузки датасетов арендаторов для метрик
+func (s *TenantServer) Datasets() []DatasetStats {
+	var result []DatasetStats
+	for _, name := range s.Tenants() {
+		s.mu.RLock()
+		state := s.tenants[name]
+		s.mu.RUnlock()
+		state.mu.RLock()
+		store, loadedAt := state.store, state.loadedAt
+		state.mu.RUnlock()
+		if store == nil {
+			continue
+		}
+		rows, err := store.CountUsers("")
+		if err != nil {
+			continue
+		}
+		result = append(result, DatasetStats{Name: name, Rows: rows, LoadedAt: loadedAt})
+	}
+	return result
+}
+
+func (s *TenantServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var state *tenantState
+	s.mu.RLock()
+	if strings.HasPrefix(r.URL.Path, tenantPathPrefix) {
+		name := strings.TrimPrefix(r.URL.Path, tenantPathPrefix)
+		rest := ""
+		if i := strings.Index(name, "/"); i >= 0 {
+			name, rest = name[:i], name[i:]
+		}
+		state = s.tenants[name]
+		if state == nil {
+			s.mu.RUnlock()
+			w.Header().Set("Content-Type", MimeSearchV2)
+			writeErrorV2(w, http.StatusNotFound, SearchErrorDetail{Code: ErrorCodeUnknownTenant, Message: "unknown tenant " + name, Field: "tenant"})
+			return
+		}
+		r = r.Clone(r.Context())
+		r.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+		r.URL.RawPath = ""
+	} else {
+		state = s.byToken[r.Header.Get("AccessToken")]
+	}
+	s.mu.RUnlock()
+
+	if state == nil {
+		// токен не принадлежит ни одному арендатору: SearchServer ответит 401 в формате нужной версии
+		state = &tenantState{}
+	}
+	if state.inFlight != nil {
+		select {
+		case state.inFlight <- struct{}{}:
+			defer func() { <-state.inFlight }()
+		default:
+			w.Header().Set("Content-Type", MimeSearchV2)
+			w.Header().Set("Retry-After", "1")
+			writeErrorV2(w, http.StatusTooManyRequests, SearchErrorDetail{Code: ErrorCodeTooManyRequests, Message: "too many requests"})
+			return
+		}
+	}
+
+	SearchServer(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, state)))
+}
+
+func (state *tenantState) reload() error {
+	store, err := state.config.Store.Open()
+	if err != nil {
+		return err
+	}
+	// один store обслуживает все запросы арендатора, поэтому вместо сортировки на месте - индексы
+	store = indexed(store)
+	if state.config.MaxRows > 0 {
+		total, err := store.CountUsers("")
+		if err != nil {
+			return err
+		}
+		if total > state.config.MaxRows {
+			return fmt.Errorf("dataset has %d rows, limit is %d", total, state.config.MaxRows)
+		}
+	}
+
+	state.mu.Lock()
+	state.store, state.loadedAt = store, time.Now()
+	state.mu.Unlock()
+	return nil
+}
+
+func (state *tenantState) authorized(token string) bool {
+	for _, allowed := range state.config.Tokens {
+		if token == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// authorized проверяет AccessToken: для запроса через TenantServer - по токенам арендатора, иначе serverAccessToken
+func authorized(r *http.Request) bool {
+	token := r.Header.Get("AccessToken")
+	if state, ok := r.Context().Value(tenantContextKey{}).(*tenantState); ok {
+		return state.authorized(token)
+	}
+	return token == serverAccessToken
+}
+
+// requestStore возвращает хранилище арендатора, если запрос пришёл через TenantServer, иначе общее хранилище serverStore.
+// Одинаковые одновременные поиски по нему выполняются один раз
+func requestStore(r *http.Request) (UserStore, error) {
+	state, ok := r.Context().Value(tenantContextKey{}).(*tenantState)
+	if !ok {
+		store, _, err := openServerStore()
+		if err != nil {
+			return nil, err
+		}
+		path := serverStore.Path
+		if path == "" {
+			path = datasetPath
+		}
+		return coalesce(r, store, "store:"+serverStore.Kind+":"+path), nil
+	}
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	if state.store == nil {
+		return nil, fmt.Errorf("tenant %s is not loaded", state.config.Name)
+	}
+	return coalesce(r, state.store, "tenant:"+state.config.Name), nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	acmeToken  = "acme-token"
+	smallToken = "small-token"
+)
+
+// newTestTenants поднимает TenantServer с арендаторами acme (dataset.xml) и small (10 синтетических строк в JSON)
+func newTestTenants(t *testing.T) (*TenantServer, string) {
+	buffer := &bytes.Buffer{}
+	if err := GenerateDataset(buffer, DatasetConfig{Rows: 10, Seed: 1}); err != nil {
+		t.Fatalf("cant generate dataset: %s", err)
+	}
+	users, err := parseUsers(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("cant parse dataset: %s", err)
+	}
+	smallPath := filepath.Join(t.TempDir(), "small.json")
+	writeJSONDataset(t, smallPath, users.List)
+
+	server, err := NewTenantServer([]Tenant{
+		{Name: "acme", Store: StoreConfig{Kind: StoreXML, Path: "./dataset.xml"}, Tokens: []string{acmeToken}},
+		{Name: "small", Store: StoreConfig{Kind: StoreJSON, Path: smallPath}, Tokens: []string{smallToken, "small-token-2"}},
+	})
+	if err != nil {
+		t.Fatalf("cant start tenants: %s", err)
+	}
+	return server, smallPath
+}
+
+func writeJSONDataset(t *testing.T, path string, rows []UserXml) {
+	buffer := &bytes.Buffer{}
+	if err := WriteUsersJSON(buffer, rows); err != nil {
+		t.Fatalf("cant encode dataset: %s", err)
+	}
+	if err := ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+}
+
+func TestTenantServerRouting(t *testing.T) {
+	server, _ := newTestTenants(t)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		acme := &SearchClient{AccessToken: acmeToken, URL: ts.URL, Version: version, Tenant: "acme"}
+		result, err := acme.FindUsers(SearchRequest{Limit: 25})
+		if err != nil || len(result.Users) != 25 || !result.NextPage {
+			t.Errorf("[%s] acme: unexpected result %v %v", version, result, err)
+		}
+
+		small := &SearchClient{AccessToken: "small-token-2", URL: ts.URL + "/", Version: version, Tenant: "small"}
+		result, err = small.FindUsers(SearchRequest{Limit: 25})
+		if err != nil || len(result.Users) != 10 || result.NextPage {
+			t.Errorf("[%s] small: unexpected result %v %v", version, result, err)
+		}
+
+		// без префикса арендатор выбирается по токену
+		byToken := &SearchClient{AccessToken: smallToken, URL: ts.URL, Version: version}
+		result, err = byToken.FindUsers(SearchRequest{Limit: 25})
+		if err != nil || len(result.Users) != 10 {
+			t.Errorf("[%s] by token: unexpected result %v %v", version, result, err)
+		}
+
+		for _, client := range []*SearchClient{
+			{AccessToken: acmeToken, URL: ts.URL, Version: version, Tenant: "small"},
+			{AccessToken: serverAccessToken, URL: ts.URL, Version: version},
+		} {
+			if _, err := client.FindUsers(SearchRequest{Limit: 1}); err == nil || err.Error() != "Bad AccessToken" {
+				t.Errorf("[%s] %+v: expected Bad AccessToken, got %v", version, client, err)
+			}
+		}
+
+		unknown := &SearchClient{AccessToken: acmeToken, URL: ts.URL, Version: version, Tenant: "nobody"}
+		if _, err := unknown.FindUsers(SearchRequest{Limit: 1}); err == nil || !strings.HasPrefix(err.Error(), ErrorCodeUnknownTenant) {
+			t.Errorf("[%s] expected unknown tenant error, got %v", version, err)
+		}
+	}
+
+	count := 0
+	small := &SearchClient{AccessToken: smallToken, URL: ts.URL, Tenant: "small"}
+	if err := small.StreamUsers(SearchRequest{}, func(User) error { count++; return nil }); err != nil || count != 10 {
+		t.Errorf("expected 10 exported users, got %d %v", count, err)
+	}
+}
+
+func TestTenantServerConformance(t *testing.T) {
+	server, _ := newTestTenants(t)
+	for _, version := range []string{APIVersion1, APIVersion2} {
+		t.Run(version, func(t *testing.T) {
+			ConformanceSuite{Handler: server, Dataset: "./dataset.xml", AccessToken: acmeToken, Version: version}.Run(t)
+		})
+	}
+}
+
+func TestTenantServerReload(t *testing.T) {
+	server, smallPath := newTestTenants(t)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	small := &SearchClient{AccessToken: smallToken, URL: ts.URL, Tenant: "small"}
+	before, _ := server.LoadedAt("small")
+
+	writeJSONDataset(t, smallPath, []UserXml{{ID: 1, FirstName: "Only", LastName: "One"}})
+	result, err := small.FindUsers(SearchRequest{Limit: 25})
+	if err != nil || len(result.Users) != 10 {
+		t.Errorf("dataset should not change before reload: %v %v", result, err)
+	}
+
+	if err := server.Reload("small"); err != nil {
+		t.Fatalf("unexpected reload error: %s", err)
+	}
+	result, err = small.FindUsers(SearchRequest{Limit: 25})
+	if err != nil || len(result.Users) != 1 || result.Users[0].Name != "Only One" {
+		t.Errorf("expected reloaded dataset, got %v %v", result, err)
+	}
+	if after, _ := server.LoadedAt("small"); !after.After(before) {
+		t.Errorf("expected LoadedAt to move forward")
+	}
+
+	if err := ioutil.WriteFile(smallPath, []byte(`[{"id": 1`), 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+	errs := server.ReloadAll()
+	if len(errs) != 1 || errs["small"] == nil {
+		t.Errorf("expected only small to fail, got %v", errs)
+	}
+	result, err = small.FindUsers(SearchRequest{Limit: 25})
+	if err != nil || len(result.Users) != 1 {
+		t.Errorf("failed reload should keep old dataset: %v %v", result, err)
+	}
+
+	if err := server.Reload("nobody"); err == nil {
+		t.Errorf("expected error for unknown tenant")
+	}
+}
+
+func TestTenantServerInFlightLimit(t *testing.T) {
+	server, err := NewTenantServer([]Tenant{
+		{Name: "acme", Store: StoreConfig{Path: "./dataset.xml"}, Tokens: []string{acmeToken}, MaxInFlight: 1},
+	})
+	if err != nil {
+		t.Fatalf("cant start tenants: %s", err)
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	acme := &SearchClient{AccessToken: acmeToken, URL: ts.URL, Tenant: "acme"}
+
+	// занимаем единственный слот, как будто запрос ещё выполняется
+	server.tenants["acme"].inFlight <- struct{}{}
+	if _, err := acme.FindUsers(SearchRequest{Limit: 1}); err == nil || !strings.HasPrefix(err.Error(), ErrorCodeTooManyRequests) {
+		t.Errorf("expected too many requests, got %v", err)
+	}
+	<-server.tenants["acme"].inFlight
+	if _, err := acme.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Errorf("unexpected error after slot is free: %s", err)
+	}
+}
+
+func TestNewTenantServerErrors(t *testing.T) {
+	dataset := StoreConfig{Path: "./dataset.xml"}
+	cases := map[string][]Tenant{
+		"empty name":   {{Store: dataset}},
+		"slash":        {{Name: "a/b", Store: dataset}},
+		"duplicate":    {{Name: "a", Store: dataset}, {Name: "a", Store: dataset}},
+		"shared token": {{Name: "a", Store: dataset, Tokens: []string{"x"}}, {Name: "b", Store: dataset, Tokens: []string{"x"}}},
+		"max rows":     {{Name: "a", Store: dataset, MaxRows: 10}},
+		"bad store":    {{Name: "a", Store: StoreConfig{Kind: StoreCSV, Path: "./missing.csv"}}},
+	}
+	for name, tenants := range cases {
+		if _, err := NewTenantServer(tenants); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoadTenantServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	config := `[{"name": "acme", "store": {"kind": "xml", "path": "./dataset.xml"}, "tokens": ["acme-token"], "max_in_flight": 4}]`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("cant write config: %s", err)
+	}
+	server, err := loadTenantServer(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if names := server.Tenants(); len(names) != 1 || names[0] != "acme" || cap(server.tenants["acme"].inFlight) != 4 {
+		t.Errorf("unexpected tenants %v", names)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// Трассировку через OpenTelemetry подключает сборка с тегом otel (trace_otel.go): она заполняет хуки ниже.
+// Без тега спаны не создаются и хуки ничего не делают
+
+// searchSpan - спан поиска, как его видят клиент, сервер и хранилище
+type searchSpan interface {
+	// SetAttribute добавляет атрибут; значения - строки, числа и bool
+	SetAttribute(key string, value interface{})
+	// SetError отмечает, что операция закончилась ошибкой
+	SetError(err error)
+	End()
+}
+
+// noSpan - спан сборки без трассировки
+type noSpan struct{}
+
+func (noSpan) SetAttribute(string, interface{}) {}
+
+func (noSpan) SetError(error) {}
+
+func (noSpan) End() {}
+
+var (
+	// startClientSpan начинает клиентский спан, дочерний к спану из ctx, и возвращает ctx с ним
+	startClientSpan = func(ctx context.Context, name string) (context.Context, searchSpan) {
+		return ctx, noSpan{}
+	}
+	// spanFromContext возвращает текущий спан ctx
+	spanFromContext = func(ctx context.Context) searchSpan {
+		return noSpan{}
+	}
+	// contextWithSpanFrom переносит текущий спан из from в ctx
+	contextWithSpanFrom = func(ctx, from context.Context) context.Context {
+		return ctx
+	}
+	// injectTraceContext передаёт контекст трассы из ctx в заголовках исходящего запроса
+	injectTraceContext = func(ctx context.Context, header http.Header) {}
+
+	// traceHandler оборачивает обработчик серверным спаном; nil - трассировка не собрана
+	traceHandler func(next http.Handler) http.Handler
+	// exportSpans начинает писать спаны в out по JSON-объекту на строку; nil - трассировка не собрана
+	exportSpans func(out io.Writer) error
+)
+
+// traceSearch записывает параметры поиска в атрибуты спана
+func traceSearch(span searchSpan, req SearchRequest) {
+	span.SetAttribute("search.limit", req.Limit)
+	span.SetAttribute("search.offset", req.Offset)
+	span.SetAttribute("search.order_field", req.OrderField)
+	span.SetAttribute("search.order_by", req.OrderBy)
+}
@@ -0,0 +1,126 @@
+//go:build otel
+
+package main
+
+// трассировка через OpenTelemetry: спаны создаёт глобальный TracerProvider (otel.SetTracerProvider),
+// контекст трассы передаётся в заголовках W3C traceparent и tracestate
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName - имя инструментирующей библиотеки в спанах
+const tracerName = "github.com/asannikov/golang-webservices-1-week4"
+
+var tracePropagator = propagation.TraceContext{}
+
+func init() {
+	startClientSpan = func(ctx context.Context, name string) (context.Context, searchSpan) {
+		ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+		return ctx, otelSpan{span}
+	}
+	spanFromContext = func(ctx context.Context) searchSpan {
+		return otelSpan{trace.SpanFromContext(ctx)}
+	}
+	contextWithSpanFrom = func(ctx, from context.Context) context.Context {
+		return trace.ContextWithSpan(ctx, trace.SpanFromContext(from))
+	}
+	injectTraceContext = func(ctx context.Context, header http.Header) {
+		tracePropagator.Inject(ctx, propagation.HeaderCarrier(header))
+	}
+	traceHandler = TraceHandler
+	exportSpans = func(out io.Writer) error {
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return err
+		}
+		// спаны пишутся сразу по завершении: серверу некогда сбросить пачку при остановке
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+		return nil
+	}
+}
+
+// otelSpan - searchSpan поверх спана OpenTelemetry
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttribute(key string, value interface{}) {
+	switch value := value.(type) {
+	case int:
+		s.span.SetAttributes(attribute.Int(key, value))
+	case string:
+		s.span.SetAttributes(attribute.String(key, value))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, value))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+}
+
+func (s otelSpan) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
+
+// TraceHandler оборачивает обработчик серверным спаном, продолжая трассу из traceparent запроса.
+// Параметры поиска и число найденных записей добавляют в спан сами обработчики
+func TraceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		// трейсер берётся на каждый запрос, чтобы подхватить TracerProvider, заданный после создания обработчика
+		ctx, span := otel.Tracer(tracerName).Start(ctx, "SearchServer",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("http.method", r.Method), attribute.String("http.target", r.URL.Path)))
+		defer span.End()
+
+		traced := &tracedResponse{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(traced, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.status_code", traced.status))
+		if traced.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(traced.status))
+		}
+	})
+}
+
+// tracedResponse запоминает код ответа для спана
+type tracedResponse struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (t *tracedResponse) WriteHeader(status int) {
+	if !t.wroteHeader {
+		t.status, t.wroteHeader = status, true
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *tracedResponse) Write(data []byte) (int, error) {
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(data)
+}
+
+func (t *tracedResponse) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
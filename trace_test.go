@@ -0,0 +1,166 @@
+//go:build otel
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useTracing подменяет глобальный TracerProvider до конца теста и возвращает экспортёр его спанов
+func useTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func newTracedServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(TraceHandler(http.HandlerFunc(SearchServer)))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// spansOfKind отбирает спаны клиента или сервера: в тестах они попадают в один экспортёр
+func spansOfKind(spans tracetest.SpanStubs, kind trace.SpanKind) tracetest.SpanStubs {
+	var result tracetest.SpanStubs
+	for _, span := range spans {
+		if span.SpanKind == kind {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func checkAttributes(t *testing.T, who string, span tracetest.SpanStub, expected map[attribute.Key]interface{}) {
+	attributes := spanAttributes(span)
+	for key, value := range expected {
+		if attributes[key].AsInterface() != value {
+			t.Errorf("%s attribute %s: expected %v, got %v", who, key, value, attributes[key].AsInterface())
+		}
+	}
+}
+
+func TestTracingClientServer(t *testing.T) {
+	exporter := useTracing(t)
+	ts := newTracedServer(t)
+	client := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "handler")
+	if _, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1, Offset: 2, OrderField: "Age", OrderBy: OrderByAsc}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	parent.End()
+
+	spans := spansOfKind(exporter.GetSpans(), trace.SpanKindClient)
+	if len(spans) != 1 || spans[0].Name != "SearchClient.FindUsers" {
+		t.Fatalf("unexpected client spans %+v", spans)
+	}
+	clientSpan := spans[0]
+	if clientSpan.SpanContext.TraceID() != parent.SpanContext().TraceID() || clientSpan.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("client span must continue the caller's trace")
+	}
+	checkAttributes(t, "client", clientSpan, map[attribute.Key]interface{}{
+		"search.limit": int64(1), "search.offset": int64(2), "search.order_field": "Age", "search.order_by": int64(OrderByAsc),
+		"search.result_count": int64(1), "http.status_code": int64(http.StatusOK),
+	})
+
+	spans = spansOfKind(exporter.GetSpans(), trace.SpanKindServer)
+	if len(spans) != 1 {
+		t.Fatalf("unexpected server spans %+v", spans)
+	}
+	serverSpan := spans[0]
+	if serverSpan.SpanContext.TraceID() != clientSpan.SpanContext.TraceID() || serverSpan.Parent.SpanID() != clientSpan.SpanContext.SpanID() || !serverSpan.Parent.IsRemote() {
+		t.Errorf("server span must be a child of the client span")
+	}
+	// клиент просит на одну запись больше, чтобы узнать про следующую страницу
+	checkAttributes(t, "server", serverSpan, map[attribute.Key]interface{}{
+		"search.limit": int64(2), "search.offset": int64(2), "search.order_field": "age",
+		"http.status_code": int64(http.StatusOK), "http.method": "GET",
+	})
+	if count := spanAttributes(serverSpan)["search.result_count"].AsInt64(); count < 2 {
+		t.Errorf("expected server result count, got %d", count)
+	}
+	if serverSpan.Status.Code == codes.Error || clientSpan.Status.Code == codes.Error || serverSpan.EndTime.Before(serverSpan.StartTime) {
+		t.Errorf("unexpected span statuses %+v %+v", clientSpan.Status, serverSpan.Status)
+	}
+}
+
+func TestTracingErrors(t *testing.T) {
+	exporter := useTracing(t)
+	ts := newTracedServer(t)
+	client := &SearchClient{AccessToken: serverAccessToken, URL: ts.URL}
+
+	if _, err := client.FindUsers(SearchRequest{OrderField: "bad"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	spans := spansOfKind(exporter.GetSpans(), trace.SpanKindClient)
+	if len(spans) != 1 || spans[0].Status.Code != codes.Error || spans[0].Parent.IsValid() || len(spans[0].Events) == 0 {
+		t.Errorf("expected root client span with error, got %+v", spans)
+	}
+	// ошибка запроса на сервере - не ошибка сервера
+	spans = spansOfKind(exporter.GetSpans(), trace.SpanKindServer)
+	if len(spans) != 1 || spanAttributes(spans[0])["http.status_code"].AsInt64() != http.StatusBadRequest || spans[0].Status.Code == codes.Error {
+		t.Errorf("unexpected server span %+v", spans)
+	}
+}
+
+func TestTracingUnsampled(t *testing.T) {
+	exporter := useTracing(t)
+	ts := newTracedServer(t)
+	req, _ := http.NewRequest("GET", ts.URL+"?limit=1", nil)
+	req.Header.Set("AccessToken", serverAccessToken)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response %v %v", resp, err)
+	}
+	resp.Body.Close()
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("unsampled trace must not be exported, got %+v", spans)
+	}
+}
+
+func TestTracedSpanLog(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	out := &bytes.Buffer{}
+	if err := exportSpans(out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "op")
+	span.End()
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON span, got %q: %s", out.String(), err)
+	}
+	if record["Name"] != "op" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
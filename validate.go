@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatasetSchema - правила, которым должна соответствовать каждая строка датасета
+type DatasetSchema struct {
+	// поля, которые не могут быть пустыми
+	Required []string
+	MinAge   int
+	MaxAge   int
+	// сколько замечаний попадает в отчёт, 0 - все. Счётчики в Counts считаются всегда полностью
+	MaxIssues int
+}
+
+var DefaultSchema = DatasetSchema{
+	Required: []string{"id", "guid", "isActive", "balance", "age", "first_name", "last_name", "gender", "email", "registered"},
+	MinAge:   0,
+	MaxAge:   150,
+}
+
+// правила, по которым группируются замечания
+const (
+	RuleRequired = "required"
+	RuleFormat   = "format"
+	RuleRange    = "range"
+	RuleUnique   = "unique"
+)
+
+const registeredLayout = "2006-01-02T15:04:05 -07:00"
+
+var (
+	emailFormat   = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[A-Za-z]+$`)
+	phoneFormat   = regexp.MustCompile(`^\+\d{1,3} \(\d{3}\) \d{3}-\d{4}$`)
+	guidFormat    = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	balanceFormat = regexp.MustCompile(`^\$\d{1,3}(,\d{3})*\.\d{2}$`)
+)
+
+// ValidationReport - машиночитаемый отчёт о качестве датасета
+type ValidationReport struct {
+	Rows        int `json:"rows"`
+	InvalidRows int `json:"invalid_rows"`
+	// количество замечаний по правилам
+	Counts map[string]int    `json:"counts"`
+	Issues []ValidationIssue `json:"issues"`
+	// в Issues попали не все замечания из-за MaxIssues
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ValidationIssue - нарушение правила схемы в одном поле строки
+type ValidationIssue struct {
+	// строка файла, на которой начинается <row>
+	Line    int    `json:"line"`
+	ID      string `json:"id,omitempty"`
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+// Valid сообщает, что в датасете нет ни одного замечания
+func (report *ValidationReport) Valid() bool {
+	return report.InvalidRows == 0
+}
+
+// Summary - одна строка для лога
+func (report *ValidationReport) Summary() string {
+	return fmt.Sprintf("%d rows, %d invalid (required: %d, format: %d, range: %d, unique: %d)",
+		report.Rows, report.InvalidRows, report.Counts[RuleRequired], report.Counts[RuleFormat],
+		report.Counts[RuleRange], report.Counts[RuleUnique])
+}
+
+// schemaRow - строка датасета со всеми полями, которые проверяет схема, в исходном виде
+type schemaRow struct {
+	rawRow
+	Registered string `xml:"registered"`
+}
+
+func (row schemaRow) field(name string) string {
+	switch name {
+	case "id":
+		return row.ID
+	case "guid":
+		return row.GUID
+	case "isActive":
+		return row.Active
+	case "balance":
+		return row.Balance
+	case "picture":
+		return row.Picture
+	case "age":
+		return row.Age
+	case "eyeColor":
+		return row.EyeColor
+	case "first_name":
+		return row.FirstName
+	case "last_name":
+		return row.LastName
+	case "gender":
+		return row.Gender
+	case "company":
+		return row.Company
+	case "email":
+		return row.Email
+	case "phone":
+		return row.Phone
+	case "address":
+		return row.Address
+	case "about":
+		return row.About
+	case "registered":
+		return row.Registered
+	}
+	return ""
+}
+
+// ValidateDataset проверяет датасет в формате dataset.xml по схеме, читая его потоково.
+// Ошибка возвращается только если XML не удалось разобрать, нарушения схемы попадают в отчёт
+func ValidateDataset(r io.Reader, schema DatasetSchema) (*ValidationReport, error) {
+	report := &ValidationReport{Counts: map[string]int{}, Issues: []ValidationIssue{}}
+	ids := map[string]int{}
+	guids := map[string]int{}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		line, _ := decoder.InputPos()
+		row := schemaRow{}
+		if err := decoder.DecodeElement(&row, &start); err != nil {
+			return report, err
+		}
+		report.Rows++
+
+		issues := schema.check(row, ids, guids)
+		if id := strings.TrimSpace(row.ID); id != "" && ids[id] == 0 {
+			ids[id] = line
+		}
+		if row.GUID != "" && guids[row.GUID] == 0 {
+			guids[row.GUID] = line
+		}
+		if len(issues) == 0 {
+			continue
+		}
+		report.InvalidRows++
+		for _, issue := range issues {
+			issue.Line = line
+			issue.ID = strings.TrimSpace(row.ID)
+			report.Counts[issue.Rule]++
+			if schema.MaxIssues > 0 && len(report.Issues) >= schema.MaxIssues {
+				report.Truncated = true
+				continue
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+}
+
+// check возвращает нарушения схемы в строке; ids и guids - уже встреченные значения и их строки
+func (schema DatasetSchema) check(row schemaRow, ids, guids map[string]int) []ValidationIssue {
+	var issues []ValidationIssue
+	add := func(field, rule, value, message string) {
+		issues = append(issues, ValidationIssue{Field: field, Rule: rule, Value: value, Message: message})
+	}
+
+	for _, field := range schema.Required {
+		if strings.TrimSpace(row.field(field)) == "" {
+			add(field, RuleRequired, "", "must not be empty")
+		}
+	}
+
+	if id := strings.TrimSpace(row.ID); id != "" {
+		if value, err := strconv.Atoi(id); err != nil || value < 0 {
+			add("id", RuleFormat, row.ID, "must be a non-negative integer")
+		} else if line, ok := ids[id]; ok {
+			add("id", RuleUnique, row.ID, fmt.Sprintf("duplicates row at line %d", line))
+		}
+	}
+	if row.GUID != "" {
+		if !guidFormat.MatchString(row.GUID) {
+			add("guid", RuleFormat, row.GUID, "must be a lowercase GUID")
+		} else if line, ok := guids[row.GUID]; ok {
+			add("guid", RuleUnique, row.GUID, fmt.Sprintf("duplicates row at line %d", line))
+		}
+	}
+	if active := strings.TrimSpace(row.Active); active != "" {
+		if _, err := strconv.ParseBool(active); err != nil {
+			add("isActive", RuleFormat, row.Active, "must be true or false")
+		}
+	}
+	if age := strings.TrimSpace(row.Age); age != "" {
+		if value, err := strconv.Atoi(age); err != nil {
+			add("age", RuleFormat, row.Age, "must be an integer")
+		} else if value < schema.MinAge || value > schema.MaxAge {
+			add("age", RuleRange, row.Age, fmt.Sprintf("must be between %d and %d", schema.MinAge, schema.MaxAge))
+		}
+	}
+	if row.Balance != "" && !balanceFormat.MatchString(row.Balance) {
+		add("balance", RuleFormat, row.Balance, "must look like $1,234.56")
+	}
+	if row.Email != "" && !emailFormat.MatchString(row.Email) {
+		add("email", RuleFormat, row.Email, "must be an email address")
+	}
+	if row.Phone != "" && !phoneFormat.MatchString(row.Phone) {
+		add("phone", RuleFormat, row.Phone, "must look like +1 (800) 555-0100")
+	}
+	if row.Registered != "" {
+		if _, err := time.Parse(registeredLayout, row.Registered); err != nil {
+			add("registered", RuleFormat, row.Registered, "must look like "+registeredLayout)
+		}
+	}
+	return issues
+}
+
+func validateDatasetFile(path string, schema DatasetSchema) (*ValidationReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ValidateDataset(file, schema)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const invalidDataset = `<?xml version="1.0" encoding="UTF-8" ?>
+<root>
+  <row>
+    <id>0</id>
+    <guid>1a6fa827-62f1-45f6-b579-aaead2b47169</guid>
+    <isActive>false</isActive>
+    <balance>$2,144.93</balance>
+    <age>22</age>
+    <first_name>Boyd</first_name>
+    <last_name>Wolf</last_name>
+    <gender>male</gender>
+    <email>boydwolf@hopeli.com</email>
+    <phone>+1 (956) 593-2402</phone>
+    <registered>2017-02-05T06:23:27 -03:00</registered>
+  </row>
+  <row>
+    <id>0</id>
+    <guid>1a6fa827-62f1-45f6-b579-aaead2b47169</guid>
+    <isActive>yes</isActive>
+    <balance>2144.93</balance>
+    <age>abc</age>
+    <first_name>Hilda</first_name>
+    <last_name></last_name>
+    <gender>female</gender>
+    <email>hilda-at-example</email>
+    <phone>956-593-2402</phone>
+    <registered>05.02.2017</registered>
+  </row>
+  <row>
+    <id>2</id>
+    <guid>2a6fa827-62f1-45f6-b579-aaead2b47169</guid>
+    <isActive>true</isActive>
+    <balance>$10.00</balance>
+    <age>200</age>
+    <first_name>Old</first_name>
+    <last_name>Man</last_name>
+    <gender>male</gender>
+    <email>old@man.com</email>
+    <registered>2017-02-05T06:23:27 -03:00</registered>
+  </row>
+</root>
+`
+
+func TestValidateDataset(t *testing.T) {
+	report, err := ValidateDataset(strings.NewReader(invalidDataset), DefaultSchema)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report.Valid() || report.Rows != 3 || report.InvalidRows != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	found := map[string]ValidationIssue{}
+	for _, issue := range report.Issues {
+		found[issue.Field+"/"+issue.Rule] = issue
+	}
+	expected := []string{
+		"id/unique", "guid/unique", "isActive/format", "balance/format", "age/format",
+		"last_name/required", "email/format", "phone/format", "registered/format", "age/range",
+	}
+	for _, key := range expected {
+		if _, ok := found[key]; !ok {
+			t.Errorf("expected issue %s in %+v", key, report.Issues)
+		}
+	}
+	if len(report.Issues) != len(expected) {
+		t.Errorf("expected %d issues, got %+v", len(expected), report.Issues)
+	}
+	if issue := found["id/unique"]; issue.Line != 16 || issue.Message != "duplicates row at line 3" {
+		t.Errorf("unexpected unique issue %+v", issue)
+	}
+	if issue := found["age/range"]; issue.Line != 29 || issue.ID != "2" || issue.Value != "200" {
+		t.Errorf("unexpected range issue %+v", issue)
+	}
+	if report.Counts[RuleFormat] != 6 || report.Counts[RuleUnique] != 2 || report.Counts[RuleRequired] != 1 || report.Counts[RuleRange] != 1 {
+		t.Errorf("unexpected counts %v", report.Counts)
+	}
+}
+
+func TestValidateDatasetMaxIssues(t *testing.T) {
+	schema := DefaultSchema
+	schema.MaxIssues = 2
+	report, err := ValidateDataset(strings.NewReader(invalidDataset), schema)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(report.Issues) != 2 || !report.Truncated || report.Counts[RuleFormat] != 6 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestValidateDatasetShipped(t *testing.T) {
+	report, err := validateDatasetFile("./dataset.xml", DefaultSchema)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !report.Valid() {
+		t.Errorf("dataset.xml should be valid: %+v", report.Issues)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := GenerateDataset(buffer, DatasetConfig{Rows: 500, Seed: 1, UnicodeNamesRate: 0.3, DuplicateNamesRate: 0.3}); err != nil {
+		t.Fatalf("cant generate dataset: %s", err)
+	}
+	report, err = ValidateDataset(buffer, DefaultSchema)
+	if err != nil || !report.Valid() {
+		t.Errorf("generated dataset should be valid: %v %+v", err, report.Issues)
+	}
+}
+
+func TestValidateDatasetCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	if err := ioutil.WriteFile(path, []byte(invalidDataset), 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+
+	stdout := &bytes.Buffer{}
+	err := validateDatasetCommand([]string{"-dataset", path, "-max-age", "300"}, stdout)
+	if err == nil || !strings.Contains(err.Error(), "1 invalid") {
+		t.Errorf("expected invalid dataset error, got %v", err)
+	}
+	report := ValidationReport{}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("report is not json: %s", err)
+	}
+	if report.Rows != 3 || report.Counts[RuleRange] != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if err := validateDatasetCommand([]string{"-dataset", "./dataset.xml"}, ioutil.Discard); err != nil {
+		t.Errorf("unexpected error for valid dataset: %s", err)
+	}
+}
+
+func TestCheckDatasetOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	if err := ioutil.WriteFile(path, []byte(invalidDataset), 0644); err != nil {
+		t.Fatalf("cant write dataset: %s", err)
+	}
+
+	if err := checkDatasetOnStartup(path, ValidationStrict); err == nil {
+		t.Errorf("strict mode should reject invalid dataset")
+	}
+	if err := checkDatasetOnStartup(path, ValidationLenient); err != nil {
+		t.Errorf("lenient mode should accept invalid dataset: %s", err)
+	}
+	if err := checkDatasetOnStartup("./dataset.xml", ValidationStrict); err != nil {
+		t.Errorf("strict mode should accept valid dataset: %s", err)
+	}
+	if err := checkDatasetOnStartup("./missing.xml", ValidationOff); err != nil {
+		t.Errorf("off mode should not read dataset: %s", err)
+	}
+	if err := checkDatasetOnStartup(path, "sometimes"); err == nil {
+		t.Errorf("expected error for unknown mode")
+	}
+}
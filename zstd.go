@@ -0,0 +1,27 @@
+//go:build zstd
+
+package main
+
+// сжатие zstd для CompressHandler и SearchClient: go get github.com/klauspost/compress
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	// zstd сжимает JSON лучше и быстрее gzip, поэтому он первым идёт в Accept-Encoding клиента
+	contentEncodings = append([]contentEncoding{{
+		name: EncodingZstd,
+		newWriter: func(w io.Writer) (compressWriter, error) {
+			return zstd.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+	}}, contentEncodings...)
+}